@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/analyzer"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/builtin"
+	"thechosenzendro/zygonlang/zygonlang/codegen"
+	"thechosenzendro/zygonlang/zygonlang/diagnostic"
+	"thechosenzendro/zygonlang/zygonlang/evaluator"
+	"thechosenzendro/zygonlang/zygonlang/format"
+	"thechosenzendro/zygonlang/zygonlang/repl"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/types"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+// newFlagSet makes the flag set of a command, which reports bad usage by itself.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		commandUsage(name)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// loaderFlags are the flags of the commands that run programs.
+type loaderFlags struct {
+	path *string
+	vm   *bool
+}
+
+// addLoaderFlags adds the -path flag and the -vm flag, which runs programs on the bytecode virtual machine.
+func addLoaderFlags(flags *flag.FlagSet) loaderFlags {
+	return loaderFlags{
+		path: addPathFlag(flags),
+		vm:   flags.Bool("vm", false, "compile to bytecode and run it on a virtual machine instead of evaluating the syntax tree"),
+	}
+}
+
+// newLoader makes a module loader that looks in the directories of the -path flag and ZYGON_PATH.
+func (f loaderFlags) newLoader() *evaluator.Loader {
+	loader := loaderFor(*f.path)
+	loader.UseVM = *f.vm
+	return loader
+}
+
+// addPathFlag adds the -path flag, which lists extra directories to look for modules in.
+func addPathFlag(flags *flag.FlagSet) *string {
+	return flags.String("path", "", fmt.Sprintf("directories to look for modules in, separated by %q, before the ones in ZYGON_PATH", os.PathListSeparator))
+}
+
+// loaderFor makes a module loader that looks in the directories listed in path and ZYGON_PATH.
+func loaderFor(path string) *evaluator.Loader {
+	searchPaths := []string{}
+	for _, path := range filepath.SplitList(path) {
+		if path != "" {
+			searchPaths = append(searchPaths, path)
+		}
+	}
+	return evaluator.NewLoader(append(searchPaths, evaluator.SearchPathsFromEnv()...))
+}
+
+func readSource(filePath string) (string, bool) {
+	sourceCode, err := os.ReadFile(filePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return "", false
+	}
+	return string(sourceCode), true
+}
+
+// report prints an error of a zygon program and returns the exit code it should end with.
+func report(filePath string, sourceCode string, err error) int {
+	var crash *builtin.Crash
+	if errors.As(err, &crash) {
+		fmt.Println(crash.Error())
+		return crash.ExitCode
+	}
+	fmt.Fprint(os.Stderr, diagnostic.Render(filePath, sourceCode, err))
+	return 1
+}
+
+func runCommand(args []string) int {
+	flags := newFlagSet("run")
+	loaderFlags := addLoaderFlags(flags)
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		return commandUsage("run")
+	}
+	filePath := flags.Arg(0)
+	sourceCode, ok := readSource(filePath)
+	if !ok {
+		return 1
+	}
+	builtin.Args = flags.Args()[1:]
+
+	val, _, err := loaderFlags.newLoader().Exec(filePath, sourceCode)
+	if err != nil {
+		return report(filePath, sourceCode, err)
+	}
+	if val != nil {
+		fmt.Println(val.Inspect())
+	}
+	return 0
+}
+
+func buildCommand(args []string) int {
+	flags := newFlagSet("build")
+	path := addPathFlag(flags)
+	output := flags.String("o", "", "the file to write the executable to, named after the zygon file by default")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return commandUsage("build")
+	}
+	filePath := flags.Arg(0)
+	sourceCode, ok := readSource(filePath)
+	if !ok {
+		return 1
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+		if runtime.GOOS == "windows" {
+			*output += ".exe"
+		}
+	}
+
+	if err := codegen.Build(loaderFor(*path), filePath, sourceCode, *output); err != nil {
+		return report(filePath, sourceCode, err)
+	}
+	return 0
+}
+
+func checkCommand(args []string) int {
+	flags := newFlagSet("check")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		return commandUsage("check")
+	}
+	code := 0
+	for _, filePath := range flags.Args() {
+		sourceCode, ok := readSource(filePath)
+		if !ok {
+			code = 1
+			continue
+		}
+		if err := check(sourceCode); err != nil {
+			code = report(filePath, sourceCode, err)
+		}
+	}
+	return code
+}
+
+func check(sourceCode string) error {
+	// the lexer needs to lex indents correctly
+	tokens, err := token.Tokenize(sourceCode + "\n")
+	if err != nil {
+		return err
+	}
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		return err
+	}
+	_, err = analyzer.Analyze(program, nil)
+	return err
+}
+
+func fmtCommand(args []string) int {
+	flags := newFlagSet("fmt")
+	checkOnly := flags.Bool("check", false, "only list the files that are not formatted, without changing them")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		return commandUsage("fmt")
+	}
+	code := 0
+	for _, filePath := range flags.Args() {
+		sourceCode, ok := readSource(filePath)
+		if !ok {
+			code = 1
+			continue
+		}
+		formatted, err := format.Source(sourceCode)
+		if err != nil {
+			code = report(filePath, sourceCode, err)
+			continue
+		}
+		if formatted == sourceCode {
+			continue
+		}
+		if *checkOnly {
+			fmt.Println(filePath)
+			code = 1
+			continue
+		}
+		if err := os.WriteFile(filePath, []byte(formatted), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+func testCommand(args []string) int {
+	flags := newFlagSet("test")
+	loaderFlags := addLoaderFlags(flags)
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files := []string{}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && strings.HasSuffix(filePath, "_test.zygon") {
+				files = append(files, filePath)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	passed, failed := 0, 0
+	for _, filePath := range files {
+		p, f := runTests(loaderFlags.newLoader(), filePath)
+		passed += p
+		failed += f
+	}
+	if passed+failed == 0 {
+		fmt.Println("no tests found")
+		return 0
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runTests runs a test file and then every function in it whose name starts with test_.
+// A test fails when it stops with an error or returns false or an Error.
+func runTests(loader *evaluator.Loader, filePath string) (passed int, failed int) {
+	sourceCode, ok := readSource(filePath)
+	if !ok {
+		return 0, 1
+	}
+	_, env, err := loader.Exec(filePath, sourceCode)
+	if err != nil {
+		fmt.Printf("FAIL  %s\n", filePath)
+		report(filePath, sourceCode, err)
+		return 0, 1
+	}
+
+	names := []string{}
+	for name, val := range env.Store {
+		if val != nil && val.Type() == types.FUNCTION && strings.HasPrefix(name, "test_") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		call := ast.FunctionCall{Fn: ast.Identifier{Value: name, Slot: ast.Global}, Arguments: []ast.FunctionCallArgument{}}
+		result, err := loader.Run(filePath, ast.Program{Body: []ast.Node{call}}, env)
+		boolean, isBoolean := result.(value.Boolean)
+		_, isError := result.(value.Error)
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL  %s  %s\n", filePath, name)
+			report(filePath, sourceCode, err)
+		case isBoolean && !boolean.Value:
+			fmt.Printf("FAIL  %s  %s: returned false\n", filePath, name)
+		case isError:
+			fmt.Printf("FAIL  %s  %s: returned %s\n", filePath, name, result.Inspect())
+		default:
+			fmt.Printf("ok    %s  %s\n", filePath, name)
+			passed += 1
+			continue
+		}
+		failed += 1
+	}
+	return passed, failed
+}
+
+func replCommand(args []string) int {
+	flags := newFlagSet("repl")
+	loaderFlags := addLoaderFlags(flags)
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	repl.Start(os.Stdin, os.Stdout, loaderFlags.newLoader())
+	return 0
+}
+
+func tokensCommand(args []string) int {
+	flags := newFlagSet("tokens")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return commandUsage("tokens")
+	}
+	filePath := flags.Arg(0)
+	sourceCode, ok := readSource(filePath)
+	if !ok {
+		return 1
+	}
+	tokens, err := token.Tokenize(sourceCode + "\n")
+	if err != nil {
+		return report(filePath, sourceCode, err)
+	}
+	for _, tok := range tokens.Contents {
+		fmt.Printf("%d:%d\t%s\t%q\n", tok.Span.Start.Line, tok.Span.Start.Column, tok.Type, tok.Value)
+	}
+	return 0
+}
+
+func astCommand(args []string) int {
+	flags := newFlagSet("ast")
+	if flags.Parse(args) != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return commandUsage("ast")
+	}
+	filePath := flags.Arg(0)
+	sourceCode, ok := readSource(filePath)
+	if !ok {
+		return 1
+	}
+	tokens, err := token.Tokenize(sourceCode + "\n")
+	if err != nil {
+		return report(filePath, sourceCode, err)
+	}
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		return report(filePath, sourceCode, err)
+	}
+	fmt.Print(ast.Dump(program))
+	return 0
+}
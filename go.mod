@@ -2,6 +2,4 @@ module thechosenzendro/zygonlang
 
 go 1.22.5
 
-require github.com/tiendc/go-deepcopy v0.5.1
-
 require github.com/elliotchance/orderedmap/v2 v2.2.0
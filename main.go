@@ -3,25 +3,92 @@ package main
 import (
 	"fmt"
 	"os"
-	"thechosenzendro/zygonlang/zygonlang/evaluator"
+	"thechosenzendro/zygonlang/zygonlang/codegen"
 )
 
+const Version = "0.1.0"
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands []command
+
+// commands are filled in by init, because their usage messages refer back to them
+func init() {
+	commands = []command{
+		{"run", "[-path <dirs>] [-vm] <file> [arguments...]", "runs a zygon file, giving it the arguments", runCommand},
+		{"build", "[-path <dirs>] [-o <file>] <file>", "compiles a zygon file and the modules it uses to an executable", buildCommand},
+		{"check", "<file>...", "parses and typechecks files without running them", checkCommand},
+		{"fmt", "[--check] <file>...", "formats files in place", fmtCommand},
+		{"test", "[-path <dirs>] [-vm] [path...]", "runs the test_ functions in *_test.zygon files", testCommand},
+		{"repl", "[-path <dirs>] [-vm]", "starts an interactive session", replCommand},
+		{"tokens", "<file>", "prints the tokens of a file", tokensCommand},
+		{"ast", "<file>", "prints the syntax tree of a file", astCommand},
+	}
+}
+
+// commandNotes explain what a command needs, beyond its arguments
+var commandNotes = map[string]string{
+	"build": "Building needs the go command and the source code of zygon, which the executable is built with.\n" +
+		"The source code is looked for in " + codegen.RootEnv + ", then in the directory zygon was built from,\n" +
+		"so a zygon that was installed without its source code needs " + codegen.RootEnv + " to be set.\n" +
+		"The go command has to support the Go version in the go.mod of the source code.",
+}
+
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "run" {
-		sourceCode, err := os.ReadFile(os.Args[2])
-		if err != nil {
-			panic(err)
-		}
+	os.Exit(dispatch(os.Args[1:]))
+}
 
-		val, _ := evaluator.Exec(string(sourceCode))
-		if val != nil {
-			fmt.Println(val.Inspect())
-		} else {
-			fmt.Println(val)
+// dispatch runs the command named by the first argument and returns the exit code.
+// 0 means success, 1 means an error in the zygon code and 2 means bad usage.
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return 2
+	}
+	switch args[0] {
+	case "-h", "--help", "help":
+		usage(os.Stdout)
+		return 0
+	case "-v", "--version", "version":
+		fmt.Println("zygon " + Version)
+		return 0
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
 		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return 2
+}
 
-	} else {
-		fmt.Println("Zygon commands:")
-		fmt.Println("	run <file_path> - runs a zygon file")
+func usage(out *os.File) {
+	fmt.Fprintln(out, "Usage: zygon <command> [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Zygon commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "	%-8s %-44s %s\n", cmd.name, cmd.args, cmd.usage)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "	%-53s %s\n", "--help, -h", "shows this message")
+	fmt.Fprintf(out, "	%-53s %s\n", "--version, -v", "shows the version of zygon")
+}
+
+// commandUsage reports bad usage of a command.
+func commandUsage(name string) int {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			fmt.Fprintf(os.Stderr, "Usage: zygon %s %s\n", cmd.name, cmd.args)
+			if notes, ok := commandNotes[name]; ok {
+				fmt.Fprintln(os.Stderr, notes)
+			}
+		}
 	}
+	return 2
 }
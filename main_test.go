@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// quiet sends what the commands print to nowhere and gives them no input until the test ends.
+func quiet(t *testing.T) {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, stdout, stderr := os.Stdin, os.Stdout, os.Stderr
+	os.Stdin, os.Stdout, os.Stderr = devNull, devNull, devNull
+	t.Cleanup(func() {
+		os.Stdin, os.Stdout, os.Stderr = stdin, stdout, stderr
+		devNull.Close()
+	})
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good.zygon":           "using IO\n\nIO.log(1)\n",
+		"unformatted.zygon":    "x:   1\n",
+		"syntax.zygon":         "x: 1 +\n",
+		"undefined.zygon":      "y\n",
+		"runtime.zygon":        "pick(n):\n    case n:\n        0: \"zero\"\n        default: {}\npick(0) + 1\n",
+		"pass/pass_test.zygon": "test_pass():\n    true\n",
+		"fail/fail_test.zygon": "test_fail():\n    false\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{}, 2},
+		{[]string{"--help"}, 0},
+		{[]string{"--version"}, 0},
+		{[]string{"unknown"}, 2},
+
+		{[]string{"run", file("good.zygon")}, 0},
+		{[]string{"run", "-vm", file("good.zygon")}, 0},
+		{[]string{"run", file("syntax.zygon")}, 1},
+		{[]string{"run", file("undefined.zygon")}, 1},
+		{[]string{"run", file("runtime.zygon")}, 1},
+		{[]string{"run", "-vm", file("runtime.zygon")}, 1},
+		{[]string{"run", file("missing.zygon")}, 1},
+		{[]string{"run"}, 2},
+		{[]string{"run", "-unknown", file("good.zygon")}, 2},
+
+		{[]string{"build", file("syntax.zygon")}, 1},
+		{[]string{"build"}, 2},
+
+		{[]string{"check", file("good.zygon"), file("runtime.zygon")}, 0},
+		{[]string{"check", file("good.zygon"), file("undefined.zygon")}, 1},
+		{[]string{"check"}, 2},
+
+		{[]string{"fmt", "--check", file("good.zygon")}, 0},
+		{[]string{"fmt", "--check", file("unformatted.zygon")}, 1},
+		{[]string{"fmt", "--check", file("syntax.zygon")}, 1},
+		{[]string{"fmt"}, 2},
+
+		{[]string{"test", file("pass")}, 0},
+		{[]string{"test", file("fail")}, 1},
+		{[]string{"test", "-vm", file("fail")}, 1},
+		{[]string{"test", file("missing")}, 1},
+		{[]string{"test", "-unknown"}, 2},
+
+		{[]string{"repl"}, 0},
+		{[]string{"repl", "-unknown"}, 2},
+
+		{[]string{"tokens", file("good.zygon")}, 0},
+		{[]string{"tokens"}, 2},
+		{[]string{"ast", file("good.zygon")}, 0},
+		{[]string{"ast", file("syntax.zygon")}, 1},
+		{[]string{"ast"}, 2},
+	}
+	quiet(t)
+	for _, test := range tests {
+		code := dispatch(test.args)
+		if code != test.code {
+			t.Errorf("zygon %v: expected exit code %d, got %d", test.args, test.code, code)
+		}
+	}
+}
@@ -2,15 +2,46 @@ package analyzer
 
 import (
 	"fmt"
-	"reflect"
+	"math"
+	"strconv"
 	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/builtin"
 	ordmap "thechosenzendro/zygonlang/zygonlang/orderedmap"
 	"thechosenzendro/zygonlang/zygonlang/token"
 	"thechosenzendro/zygonlang/zygonlang/types"
+	"thechosenzendro/zygonlang/zygonlang/value"
 
 	"github.com/elliotchance/orderedmap/v2"
 )
 
+var builtinLib = builtin.BuiltinLib()
+
+// TypeError is a value being used where its type does not fit.
+type TypeError struct {
+	token.Span
+	Message string
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Start.Line, e.Start.Column, e.Message)
+}
+func (e *TypeError) Msg() string { return e.Message }
+
+func errorAt(span token.Span, format string, args ...any) *TypeError {
+	return &TypeError{Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// recoverError stops a TypeError raised deeper in the analyzer and stores it in err.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		typeErr, ok := r.(*TypeError)
+		if !ok {
+			panic(r)
+		}
+		*err = typeErr
+	}
+}
+
 func Map[T, V any](ts []T, fn func(T) V) []V {
 	result := make([]V, len(ts))
 	for i, t := range ts {
@@ -19,15 +50,20 @@ func Map[T, V any](ts []T, fn func(T) V) []V {
 	return result
 }
 
-func Analyze(program ast.Program) ast.Program {
-	program = Typecheck(program)
-	return program
-}
-
-func Typecheck(program ast.Program) ast.Program {
-	typeEnv := &types.TypeEnvironment{
-		Store: map[string]*types.Type{},
-		Outer: nil,
+// Analyze resolves the names of a program and typechecks it in typeEnv, or in an empty
+// environment when typeEnv is nil. Declarations made by the program stay in typeEnv,
+// so later programs can use them.
+func Analyze(program ast.Program, typeEnv *types.TypeEnvironment) (_ ast.Program, err error) {
+	defer recoverError(&err)
+	if typeEnv == nil {
+		typeEnv = &types.TypeEnvironment{Store: map[string]*types.Type{}, Outer: nil}
+	}
+	program, globals := resolveNames(program, typeEnv)
+	// functions can use globals declared after them, whose types are not known yet
+	for _, name := range globals {
+		if _, ok := typeEnv.Get(name); !ok {
+			typeEnv.Set(name, nil)
+		}
 	}
 	for _, node := range program.Body {
 		run := true
@@ -45,7 +81,7 @@ func Typecheck(program ast.Program) ast.Program {
 			resolveType(node, typeEnv)
 		}
 	}
-	return program
+	return program, nil
 }
 
 func resolveType(node ast.Node, typeEnv *types.TypeEnvironment) *types.Type {
@@ -55,7 +91,12 @@ func resolveType(node ast.Node, typeEnv *types.TypeEnvironment) *types.Type {
 	case ast.BooleanLiteral:
 		return types.NewType(types.BOOL, nil)
 	case ast.TextLiteral:
+		for _, part := range node.Parts {
+			resolveType(part, typeEnv)
+		}
 		return types.NewType(types.TEXT, nil)
+	case ast.Grouped:
+		return resolveType(node.Value, typeEnv)
 	case ast.PrefixExpression:
 		switch node.Operator {
 		case token.NOT:
@@ -73,9 +114,18 @@ func resolveType(node ast.Node, typeEnv *types.TypeEnvironment) *types.Type {
 			assert(node.Right, types.NewType(types.NUMBER, nil), typeEnv)
 
 			return types.NewType(types.NUMBER, nil)
+		case op == token.GREATER_THAN || op == token.LESSER_THAN:
+			assert(node.Left, types.NewType(types.NUMBER, nil), typeEnv)
+			assert(node.Right, types.NewType(types.NUMBER, nil), typeEnv)
+			return types.NewType(types.BOOL, nil)
+		case op == token.AND || op == token.OR:
+			assert(node.Left, types.NewType(types.BOOL, nil), typeEnv)
+			assert(node.Right, types.NewType(types.BOOL, nil), typeEnv)
+			return types.NewType(types.BOOL, nil)
 		case op == token.IS || op == token.IS_NOT:
+			// tables with other entries can still be compared
 			leftType := resolveType(node.Left, typeEnv)
-			assert(node.Right, leftType, typeEnv)
+			assert(node.Right, loosen(leftType), typeEnv)
 			return types.NewType(types.BOOL, nil)
 		}
 	case ast.Block:
@@ -98,10 +148,96 @@ func resolveType(node ast.Node, typeEnv *types.TypeEnvironment) *types.Type {
 		}
 		return resType
 	case ast.CaseExpression:
+		var subjectType *types.Type
+		if node.Subject != nil {
+			subjectType = resolveType(node.Subject, typeEnv)
+		}
+		checkCase(node, subjectType, typeEnv)
+		// like the value of a chain, the type of a case is only known when all of its arms
+		// have the same type
+		blocks := []ast.Block{}
+		envs := []*types.TypeEnvironment{}
+		for _, arm := range node.Cases {
+			if node.Subject == nil {
+				for _, condition := range conditions(arm.Pattern) {
+					assert(condition, types.NewType(types.BOOL, nil), typeEnv)
+				}
+			} else {
+				resolvePattern(arm.Pattern, typeEnv)
+			}
+			armEnv := &types.TypeEnvironment{
+				Store: map[string]*types.Type{},
+				Outer: typeEnv,
+			}
+			bindPattern(arm.Pattern, armEnv)
+			if arm.Guard != nil {
+				assert(arm.Guard, types.NewType(types.BOOL, nil), armEnv)
+			}
+			blocks, envs = append(blocks, arm.Block), append(envs, armEnv)
+		}
+		if node.Default != nil {
+			blocks = append(blocks, *node.Default)
+			envs = append(envs, &types.TypeEnvironment{
+				Store: map[string]*types.Type{},
+				Outer: typeEnv,
+			})
+		}
+		var resType *types.Type
+		for i, block := range blocks {
+			blockType := resolveType(block, envs[i])
+			if i == 0 {
+				resType = blockType
+			} else if !resType.Equal(blockType) {
+				resType = nil
+			}
+		}
+		return resType
+	case ast.ChainExpression:
+		// the value of a chain is the value of any of its lines, so its type is only
+		// known when they all have the same type
+		chainEnv := &types.TypeEnvironment{
+			Store: map[string]*types.Type{},
+			Outer: typeEnv,
+		}
+		var resType *types.Type
+		for i, line := range node.Lines {
+			lineType := resolveType(line.Value, chainEnv)
+			if i == 0 {
+				resType = lineType
+			} else if !resType.Equal(lineType) {
+				resType = nil
+			}
+			resolvePattern(line.Pattern, chainEnv)
+			bindPattern(line.Pattern, chainEnv)
+			chainEnv.Set("_", lineType)
+		}
+		return resType
 	case ast.AssignmentStatement:
 		typeEnv.Set(node.Name.Value, resolveType(node.Value, typeEnv))
 		return nil
 	case ast.AccessOperator:
+		subjectType := assert(node.Subject, types.NewType(types.TABLE, nil), typeEnv)
+		key := ""
+		switch attribute := node.Attribute.(type) {
+		case ast.Identifier:
+			key = attribute.Value
+		case ast.Grouped:
+			resolveType(attribute.Value, typeEnv)
+			// only entries without a name are known by a key that is a value
+			if number, ok := attribute.Value.(ast.NumberLiteral); ok && number.Value >= 0 && number.Value == math.Trunc(number.Value) {
+				key = strconv.Itoa(int(number.Value))
+			}
+		}
+		if key == "" || subjectType == nil || subjectType.Properties == nil {
+			return nil
+		}
+		entryType, ok := subjectType.Properties.Get(key)
+		if !ok {
+			if _, named := node.Attribute.(ast.Identifier); named {
+				panic(errorAt(node.Attribute.Range(), "%s does not have the \"%s\" attribute", ast.SubjectName(node.Subject), key))
+			}
+		}
+		return entryType
 	case ast.Identifier:
 		t, _ := typeEnv.Get(node.Value)
 		return t
@@ -112,14 +248,23 @@ func resolveType(node ast.Node, typeEnv *types.TypeEnvironment) *types.Type {
 		}
 		params := orderedmap.NewOrderedMap[string, string]()
 		for _, key := range node.Parameters.Keys() {
-			params.Set(key.Value, "")
+			params.Set(key, "")
 			paramDefault, _ := node.Parameters.Get(key)
 			if paramDefault != nil {
-				funcEnv.Set(key.Value, resolveType(paramDefault, typeEnv))
+				funcEnv.Set(key, loosen(resolveType(paramDefault, typeEnv)))
 			} else {
-				funcEnv.Set(key.Value, nil)
+				funcEnv.Set(key, nil)
 			}
 		}
+		if node.Rest != nil {
+			if rest, ok := node.Rest.Value.(ast.Identifier); ok {
+				funcEnv.Set(rest.Value, types.NewType(types.TABLE, nil))
+			}
+		}
+		// the body can call the function itself, before its parameters are known
+		if node.Name != nil {
+			typeEnv.Set(node.Name.Value, types.NewType(types.FUNCTION, nil))
+		}
 
 		retType := resolveType(node.Body, funcEnv)
 
@@ -132,34 +277,226 @@ func resolveType(node ast.Node, typeEnv *types.TypeEnvironment) *types.Type {
 			t.Properties.Set(key, x)
 		}
 
-		typeEnv.Set(node.Name.Value, t)
-
-		fmt.Println(t.Inspect(0))
+		if node.Name != nil {
+			typeEnv.Set(node.Name.Value, t)
+		}
 		return t
 
-	case ast.FunctionCall:
-	case ast.TableLiteral:
 	case ast.PubStatement:
+		resolveType(node.Public, typeEnv)
+		return nil
 	case ast.UsingStatement:
+		// what modules from files contain is not known yet, so they are Any
+		for _, module := range node.Modules {
+			var moduleType *types.Type
+			if builtinModule, ok := builtinLib.Get(ast.ModuleName(module.Module)); ok {
+				moduleType = valueType(builtinModule)
+			}
+			name := module.Module
+			for {
+				access, ok := name.(ast.AccessOperator)
+				if !ok {
+					break
+				}
+				name = access.Attribute.(ast.Name)
+			}
+			typeEnv.Set(name.(ast.Identifier).Value, moduleType)
+			for _, symbol := range module.Symbols {
+				var symbolType *types.Type
+				if moduleType != nil {
+					symbolType, _ = moduleType.Properties.Get(symbol.Value)
+				}
+				typeEnv.Set(symbol.Value, symbolType)
+			}
+		}
+		return nil
+	case ast.FunctionCall:
+		fnType := assert(node.Fn, types.NewType(types.FUNCTION, nil), typeEnv)
+		params := []string{}
+		if fnType != nil && fnType.Properties != nil {
+			for _, key := range fnType.Properties.Keys() {
+				if key != "?return_type" {
+					params = append(params, key)
+				}
+			}
+		}
+		// arguments take the parameters in order, unless they name the one they are for
+		for i, arg := range node.Arguments {
+			if arg.Value == nil {
+				continue
+			}
+			if _, ok := arg.Value.(ast.RestOperator); ok {
+				resolveType(arg.Value, typeEnv)
+				continue
+			}
+			var paramType *types.Type
+			if arg.Name != nil && fnType != nil && fnType.Properties != nil {
+				paramType, _ = fnType.Properties.Get(arg.Name.Value)
+			} else if arg.Name == nil && i < len(params) {
+				paramType, _ = fnType.Properties.Get(params[i])
+			}
+			assert(arg.Value, paramType, typeEnv)
+		}
+		if fnType == nil || fnType.Properties == nil {
+			return nil
+		}
+		returnType, _ := fnType.Properties.Get("?return_type")
+		return returnType
+	case ast.TableLiteral:
+		properties := orderedmap.NewOrderedMap[string, *types.Type]()
+		// the shape of a table with computed keys or spread tables in it is not known
+		known := true
+		positional := 0
+		for _, entry := range node.Entries {
+			switch {
+			case entry.Computed != nil:
+				resolveType(entry.Computed.Value, typeEnv)
+				resolveType(entry.Value, typeEnv)
+				known = false
+			case entry.Key != nil:
+				properties.Set(entry.Key.Value, resolveType(entry.Value, typeEnv))
+			default:
+				if _, ok := entry.Value.(ast.RestOperator); ok {
+					resolveType(entry.Value, typeEnv)
+					known = false
+					continue
+				}
+				properties.Set(strconv.Itoa(positional), resolveType(entry.Value, typeEnv))
+				positional += 1
+			}
+		}
+		if !known {
+			return types.NewType(types.TABLE, nil)
+		}
+		return types.NewType(types.TABLE, properties)
 	case ast.RestOperator:
+		return assert(node.Value, types.NewType(types.TABLE, nil), typeEnv)
 	}
-	panic(fmt.Sprintf("type error %T", node))
+	return nil
 }
 
-func assert(node ast.Node, typ *types.Type, typeEnv *types.TypeEnvironment) {
+// assert makes sure that node is of a type and returns the type it has. A name that can be
+// Any is of that type from then on.
+func assert(node ast.Node, typ *types.Type, typeEnv *types.TypeEnvironment) *types.Type {
 	switch node := node.(type) {
 	case ast.Identifier:
 		if identType, ok := typeEnv.Get(node.Value); ok {
 			if identType == nil {
-				typeEnv.Set(node.Value, typ)
+				typeEnv.Set(node.Value, loosen(typ))
 			}
 		} else {
-			panic("identifier not found")
+			panic(errorAt(node.Span, "\"%s\" is not defined", node.Value))
 		}
 	}
 	b := resolveType(node, typeEnv)
-	if !reflect.DeepEqual(b, typ) {
-		panic(fmt.Sprintf("Bad type\nExpected: %s\nGot: %s", typ.Inspect(0), b.Inspect(0)))
+	if !fits(b, typ) {
+		panic(errorAt(node.Range(), "Bad type, expected %s, got %s", typ.Inspect(0), b.Inspect(0)))
 	}
+	return b
+}
 
+// fits tells whether a value of type b can be used where one of typ is expected. Any fits
+// everywhere, and a type without properties only asks for its base type. A table has to have
+// every entry of the one expected, but it can have more. Functions are only told apart from
+// other values.
+func fits(b *types.Type, typ *types.Type) bool {
+	if b == nil || typ == nil {
+		return true
+	}
+	if b.Base != typ.Base {
+		return false
+	}
+	if typ.Properties == nil || b.Properties == nil || typ.Base == types.FUNCTION {
+		return true
+	}
+	for _, key := range typ.Properties.Keys() {
+		expected, _ := typ.Properties.Get(key)
+		entryType, ok := b.Properties.Get(key)
+		if !ok || !fits(entryType, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// loosen returns the type of a value that is only known to be of typ, like a parameter with
+// a default. Such a table has the entries of typ, but it can have others too, so its shape
+// is not known.
+func loosen(typ *types.Type) *types.Type {
+	if typ != nil && typ.Base == types.TABLE {
+		return types.NewType(types.TABLE, nil)
+	}
+	return typ
+}
+
+// resolvePattern resolves the values a pattern is compared to, where the pattern is.
+func resolvePattern(pattern ast.Pattern, typeEnv *types.TypeEnvironment) {
+	switch pattern := pattern.(type) {
+	case ast.LiteralPattern:
+		resolveType(pattern.Value, typeEnv)
+	case ast.TypePattern:
+		assert(pattern.Type, types.NewType(types.TYPE, nil), typeEnv)
+	case ast.TablePattern:
+		for _, entry := range pattern.Entries {
+			resolvePattern(entry.Value, typeEnv)
+		}
+	case ast.AlternativePattern:
+		for _, alternative := range pattern.Alternatives {
+			resolvePattern(alternative, typeEnv)
+		}
+	}
+}
+
+// valueType returns the type of a value of a builtin module.
+func valueType(val value.Value) *types.Type {
+	switch val := val.(type) {
+	case value.Table:
+		properties := orderedmap.NewOrderedMap[string, *types.Type]()
+		for _, key := range val.Entries.Keys() {
+			entry, _ := val.Entries.Get(key)
+			properties.Set(key.Inspect(), valueType(entry))
+		}
+		return types.NewType(types.TABLE, properties)
+	case value.Type:
+		return &types.Type{Base: types.TYPE, Names: types.NewType(types.BaseType(val.Value), nil)}
+	case value.BuiltinFunction:
+		return types.NewType(types.FUNCTION, nil)
+	case nil:
+		return nil
+	}
+	return types.NewType(types.BaseType(val.Type()), nil)
+}
+
+// conditions returns the conditions of an arm of a case without a subject.
+func conditions(pattern ast.Pattern) []ast.Expression {
+	if alternatives, ok := pattern.(ast.AlternativePattern); ok {
+		values := []ast.Expression{}
+		for _, alternative := range alternatives.Alternatives {
+			values = append(values, alternative.(ast.LiteralPattern).Value)
+		}
+		return values
+	}
+	return []ast.Expression{pattern.(ast.LiteralPattern).Value}
+}
+
+// bindPattern sets the types of the names a pattern binds. What the values they are matched
+// against are is not known yet, so they can be Any, and a rest is a Table.
+func bindPattern(pattern ast.Pattern, env *types.TypeEnvironment) {
+	switch pattern := pattern.(type) {
+	case ast.BindingPattern:
+		env.Set(pattern.Name.Value, nil)
+	case ast.TypePattern:
+		if pattern.Name != nil {
+			env.Set(pattern.Name.Value, typePatternType(pattern, env))
+		}
+	case ast.TablePattern:
+		for _, entry := range pattern.Entries {
+			bindPattern(entry.Value, env)
+		}
+		if pattern.Rest != nil && pattern.Rest.Name != nil {
+			env.Set(pattern.Rest.Name.Value, types.NewType(types.TABLE, nil))
+		}
+	case ast.AlternativePattern:
+		bindPattern(pattern.Alternatives[0], env)
+	}
 }
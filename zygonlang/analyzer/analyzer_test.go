@@ -0,0 +1,180 @@
+package analyzer
+
+import (
+	"errors"
+	"testing"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+func analyze(t *testing.T, sourceCode string) error {
+	t.Helper()
+	tokens, err := token.Tokenize(sourceCode)
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	_, err = Analyze(program, nil)
+	return err
+}
+
+func TestNestedRecursiveFunction(t *testing.T) {
+	sourceCode := `sum_to(n):
+    loop(i, total):
+        case i > n:
+            true: total
+            false: loop(i + 1, total + i)
+    loop(1, 0)
+sum_to(10) + 1
+`
+	if err := analyze(t, sourceCode); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTypePatternsCoverAny(t *testing.T) {
+	arms := `using Type
+
+name(x):
+    case x:
+        Type.number _: "number"
+        Type.boolean _: "boolean"
+        Type.text _: "text"
+        Type.function _: "function"
+        Type.table _: "table"
+        Type.error _: "error"
+`
+	if err := analyze(t, arms+"        Type.kind _: \"type\"\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var typeErr *TypeError
+	err := analyze(t, arms)
+	if !errors.As(err, &typeErr) || typeErr.Message != "the case does not match Type, it needs a default" {
+		t.Fatalf("expected the case to miss Type, got %v", err)
+	}
+}
+
+func TestDuplicateParameter(t *testing.T) {
+	for _, sourceCode := range []string{"f(a, a):\n    a\n", "f(a, ...a):\n    a\n"} {
+		var typeErr *TypeError
+		err := analyze(t, sourceCode)
+		if !errors.As(err, &typeErr) || typeErr.Message != "a is already a parameter" {
+			t.Fatalf("%q: expected a duplicate parameter error, got %v", sourceCode, err)
+		}
+	}
+}
+
+func TestTypePatternsByResolvedType(t *testing.T) {
+	arms := `
+        T.number _: "number"
+        T.boolean _: "boolean"
+        T.text _: "text"
+        T.function _: "function"
+        T.table _: "table"
+        T.error _: "error"
+        T.kind _: "type"
+`
+	// an alias of the Type module still names the types
+	if err := analyze(t, "using Type\nT: Type\nname(x):\n    case x:"+arms); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a table that is not the Type module does not
+	var typeErr *TypeError
+	err := analyze(t, "name(x, T):\n    case x:"+arms)
+	if !errors.As(err, &typeErr) || typeErr.Message != "the case does not match every value, it needs a default" {
+		t.Fatalf("expected the case to need a default, got %v", err)
+	}
+}
+
+func TestCaseTypeOfTablesInAnotherOrder(t *testing.T) {
+	sourceCode := `point(flip):
+    case flip is 1:
+        true: {x: 1, y: 2}
+        false: {y: 2, x: 1}
+point(true).z
+`
+	var typeErr *TypeError
+	err := analyze(t, sourceCode)
+	if !errors.As(err, &typeErr) || typeErr.Message != "the table does not have the \"z\" attribute" {
+		t.Fatalf("expected the type of the case to be known, got %v", err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceCode   string
+		message      string
+		line, column int
+	}{
+		{"undefined name", "x: 1\ny + x\n", "\"y\" is not defined", 2, 1},
+		{"undefined name in a function", "f(a):\n    a + b\n", "\"b\" is not defined", 2, 9},
+		{"parameter outside its function", "f(a):\n    a\na\n", "\"a\" is not defined", 3, 1},
+		{"binding outside its arm", "f(t):\n    case t:\n        {x}: x\n        default: x\n", "\"x\" is not defined", 4, 18},
+		{"global set in a function", "x: 1\nf():\n    x: 2\n    x\n", "Cannot reassign identifier x", 3, 5},
+		{"global set twice", "x: 1\nx: 2\n", "Cannot reassign identifier x", 2, 1},
+		{"alternative missing a binding", "f(t):\n    case t:\n        {1, x} | {2}: 0\n        default: 1\n", "\"x\" is not bound by every alternative", 3, 18},
+		{"alternatives binding other names", "f(t):\n    case t:\n        {1, x} | {y}: 0\n        default: 1\n", "\"y\" is not bound by every alternative", 3, 9},
+		{"name bound twice", "f(t):\n    case t:\n        {x, x}: x\n        default: 1\n", "\"x\" is bound twice in a pattern", 3, 13},
+	}
+	for _, test := range tests {
+		var typeErr *TypeError
+		err := analyze(t, test.sourceCode)
+		if !errors.As(err, &typeErr) || typeErr.Message != test.message {
+			t.Errorf("%s: expected %q, got %v", test.name, test.message, err)
+			continue
+		}
+		if typeErr.Start.Line != test.line || typeErr.Start.Column != test.column {
+			t.Errorf("%s: expected the error at %d:%d, got %d:%d", test.name, test.line, test.column, typeErr.Start.Line, typeErr.Start.Column)
+		}
+	}
+}
+
+func TestShadowing(t *testing.T) {
+	sourceCode := `x: 1
+f(x):
+    x + 1
+g(t):
+    case t:
+        {a: x}: x
+        default: x
+h():
+    f(x) + g({a: x})
+`
+	if err := analyze(t, sourceCode); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tokens, err := token.Tokenize("adder(n):\n    add(m):\n        n + m\n    add\nlater():\n    g\ng: 1\n")
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	program, err = Analyze(program, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	adder := program.Body[0].(ast.FunctionDeclaration)
+	add := adder.Body.Body[0].(ast.FunctionDeclaration)
+	sum := add.Body.Body[0].(ast.InfixExpression)
+	// slot 0 of a function holds _, its parameters come after it
+	if n := sum.Left.(ast.Identifier); n.Depth != 1 || n.Slot != 1 {
+		t.Errorf("expected n one scope out in slot 1, got depth %d slot %d", n.Depth, n.Slot)
+	}
+	if m := sum.Right.(ast.Identifier); m.Depth != 0 || m.Slot != 1 {
+		t.Errorf("expected m in slot 1 of its own scope, got depth %d slot %d", m.Depth, m.Slot)
+	}
+	// a function can use a global set after it
+	later := program.Body[1].(ast.FunctionDeclaration)
+	if g := later.Body.Body[0].(ast.Identifier); g.Slot != ast.Global {
+		t.Errorf("expected g to be global, got depth %d slot %d", g.Depth, g.Slot)
+	}
+}
@@ -0,0 +1,286 @@
+package analyzer
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/types"
+)
+
+// baseTypes are the types a value can have at runtime.
+var baseTypes = []types.BaseType{types.NUMBER, types.BOOL, types.TEXT, types.FUNCTION, types.TABLE, types.ERROR, types.TYPE}
+
+// checkCase makes sure that every arm of a case can be reached, and that a case without
+// a default matches every value of its subject, which has the type subjectType.
+func checkCase(node ast.CaseExpression, subjectType *types.Type, env *types.TypeEnvironment) {
+	if node.Subject == nil {
+		checkConditions(node, env)
+		return
+	}
+	// the patterns of the arms without a guard, which are taken whenever they match
+	before := []ast.Pattern{}
+	for _, arm := range node.Cases {
+		if len(missing(before, subjectType, env)) == 0 || subsumed(arm.Pattern, before, env) {
+			panic(errorAt(arm.Pattern.Range(), "this arm is never reached, the arms before it match everything it does"))
+		}
+		if arm.Guard == nil {
+			before = append(before, arm.Pattern)
+		}
+	}
+	left := missing(before, subjectType, env)
+	if node.Default != nil && len(left) == 0 {
+		panic(errorAt(node.Default.Span, "the default is never reached, the arms before it match every value"))
+	}
+	if node.Default == nil && len(left) > 0 {
+		panic(errorAt(node.Span, "the case does not match %s, it needs a default", joinOr(left)))
+	}
+}
+
+// checkConditions checks a case without a subject, which only matches every value when
+// one of its conditions is true itself.
+func checkConditions(node ast.CaseExpression, env *types.TypeEnvironment) {
+	always := false
+	for _, arm := range node.Cases {
+		if always {
+			panic(errorAt(arm.Pattern.Range(), "this arm is never reached, a condition before it is always true"))
+		}
+		if arm.Guard == nil && matchesBool(arm.Pattern, true, env) {
+			always = true
+		}
+	}
+	if node.Default != nil && always {
+		panic(errorAt(node.Default.Span, "the default is never reached, a condition before it is always true"))
+	}
+	if node.Default == nil && !always {
+		panic(errorAt(node.Span, "the case needs a default, none of its conditions is always true"))
+	}
+}
+
+// missing returns what values of a type none of the patterns match, or nothing when they
+// match every one of them. The values of Any are those of every base type.
+func missing(patterns []ast.Pattern, typ *types.Type, env *types.TypeEnvironment) []string {
+	if typ == nil {
+		left := []string{}
+		for _, base := range baseTypes {
+			left = append(left, missing(patterns, types.NewType(base, nil), env)...)
+		}
+		if len(left) == len(baseTypes) {
+			return []string{"every value"}
+		}
+		return left
+	}
+	if slices.ContainsFunc(patterns, func(pattern ast.Pattern) bool { return covers(pattern, typ, env) }) {
+		return nil
+	}
+	if typ.Base != types.BOOL {
+		return []string{string(typ.Base)}
+	}
+	left := []string{}
+	for _, b := range []bool{true, false} {
+		if !slices.ContainsFunc(patterns, func(pattern ast.Pattern) bool { return matchesBool(pattern, b, env) }) {
+			left = append(left, strconv.FormatBool(b))
+		}
+	}
+	if len(left) == 2 {
+		return []string{string(typ.Base)}
+	}
+	return left
+}
+
+// covers tells whether a pattern matches every value of a type.
+func covers(pattern ast.Pattern, typ *types.Type, env *types.TypeEnvironment) bool {
+	switch pattern := pattern.(type) {
+	case ast.WildcardPattern, ast.BindingPattern:
+		return true
+	case ast.TypePattern:
+		patternType := typePatternType(pattern, env)
+		return patternType != nil && patternType.Base == typ.Base
+	case ast.AlternativePattern:
+		return slices.ContainsFunc(pattern.Alternatives, func(alternative ast.Pattern) bool { return covers(alternative, typ, env) })
+	case ast.TablePattern:
+		if typ.Base != types.TABLE {
+			return false
+		}
+		// without a shape the table can have any entries
+		if typ.Properties == nil {
+			return len(pattern.Entries) == 0 && pattern.Rest != nil
+		}
+		if pattern.Rest == nil && len(pattern.Entries) != typ.Properties.Len() {
+			return false
+		}
+		for i, key := range entryKeys(pattern) {
+			entryType, ok := typ.Properties.Get(key)
+			if !ok || len(missing([]ast.Pattern{pattern.Entries[i].Value}, entryType, env)) > 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// matchesBool tells whether a pattern matches a boolean.
+func matchesBool(pattern ast.Pattern, b bool, env *types.TypeEnvironment) bool {
+	switch pattern := pattern.(type) {
+	case ast.LiteralPattern:
+		literal, ok := pattern.Value.(ast.BooleanLiteral)
+		return ok && literal.Value == b
+	case ast.AlternativePattern:
+		return slices.ContainsFunc(pattern.Alternatives, func(alternative ast.Pattern) bool { return matchesBool(alternative, b, env) })
+	}
+	return covers(pattern, types.NewType(types.BOOL, nil), env)
+}
+
+// subsumed tells whether the patterns before a pattern match everything it does, so it is
+// never tried.
+func subsumed(pattern ast.Pattern, before []ast.Pattern, env *types.TypeEnvironment) bool {
+	if alternatives, ok := pattern.(ast.AlternativePattern); ok {
+		for _, alternative := range alternatives.Alternatives {
+			if !subsumed(alternative, before, env) {
+				return false
+			}
+		}
+		return true
+	}
+	return slices.ContainsFunc(before, func(p ast.Pattern) bool { return subsumes(p, pattern, env) })
+}
+
+// subsumes tells whether p matches every value q does. It only knows what literals and
+// tables match, so it can say it does not when it does.
+func subsumes(p ast.Pattern, q ast.Pattern, env *types.TypeEnvironment) bool {
+	if alternatives, ok := q.(ast.AlternativePattern); ok {
+		for _, alternative := range alternatives.Alternatives {
+			if !subsumes(p, alternative, env) {
+				return false
+			}
+		}
+		return true
+	}
+	switch p := p.(type) {
+	case ast.WildcardPattern, ast.BindingPattern:
+		return true
+	case ast.AlternativePattern:
+		return slices.ContainsFunc(p.Alternatives, func(alternative ast.Pattern) bool { return subsumes(alternative, q, env) })
+	case ast.LiteralPattern:
+		literal, ok := q.(ast.LiteralPattern)
+		return ok && sameLiteral(p.Value, literal.Value)
+	case ast.TypePattern:
+		patternType := typePatternType(p, env)
+		if patternType == nil {
+			return false
+		}
+		switch q := q.(type) {
+		case ast.TypePattern:
+			qType := typePatternType(q, env)
+			return qType != nil && qType.Base == patternType.Base
+		case ast.LiteralPattern:
+			literalType := literalType(q.Value)
+			return literalType != nil && literalType.Base == patternType.Base
+		case ast.TablePattern:
+			return patternType.Base == types.TABLE
+		}
+	case ast.TablePattern:
+		table, ok := q.(ast.TablePattern)
+		if !ok {
+			return false
+		}
+		if p.Rest == nil && (table.Rest != nil || len(p.Entries) != len(table.Entries)) {
+			return false
+		}
+		tableKeys := entryKeys(table)
+		for i, key := range entryKeys(p) {
+			j := slices.Index(tableKeys, key)
+			if j == -1 || !subsumes(p.Entries[i].Value, table.Entries[j].Value, env) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// entryKeys returns the keys of the entries of a table pattern, their names or their
+// positions for the ones without a name.
+func entryKeys(pattern ast.TablePattern) []string {
+	keys := []string{}
+	positional := 0
+	for _, entry := range pattern.Entries {
+		if entry.Key != nil {
+			keys = append(keys, entry.Key.Value)
+		} else {
+			keys = append(keys, strconv.Itoa(positional))
+			positional += 1
+		}
+	}
+	return keys
+}
+
+// sameLiteral tells whether two literals of patterns always have the same value. Names
+// in the patterns of a case are evaluated in the same scope, so the same name is the same value.
+func sameLiteral(a ast.Expression, b ast.Expression) bool {
+	switch a := a.(type) {
+	case ast.NumberLiteral:
+		other, ok := b.(ast.NumberLiteral)
+		return ok && a.Value == other.Value
+	case ast.BooleanLiteral:
+		other, ok := b.(ast.BooleanLiteral)
+		return ok && a.Value == other.Value
+	case ast.TextLiteral:
+		text, ok := staticText(a)
+		other, otherOk := b.(ast.TextLiteral)
+		if !ok || !otherOk {
+			return false
+		}
+		otherText, ok := staticText(other)
+		return ok && text == otherText
+	case ast.Identifier:
+		other, ok := b.(ast.Identifier)
+		return ok && a.Value == other.Value
+	}
+	return false
+}
+
+// staticText returns the text of a text literal without any values in it.
+func staticText(literal ast.TextLiteral) (string, bool) {
+	var text strings.Builder
+	for _, part := range literal.Parts {
+		textPart, ok := part.(ast.TextPart)
+		if !ok {
+			return "", false
+		}
+		text.WriteString(textPart.Value)
+	}
+	return text.String(), true
+}
+
+// literalType returns the type of a literal, or nil when it is not one.
+func literalType(expression ast.Expression) *types.Type {
+	switch expression.(type) {
+	case ast.NumberLiteral:
+		return types.NewType(types.NUMBER, nil)
+	case ast.BooleanLiteral:
+		return types.NewType(types.BOOL, nil)
+	case ast.TextLiteral:
+		return types.NewType(types.TEXT, nil)
+	}
+	return nil
+}
+
+// typePatternType returns the type a type pattern matches when its type is known, like
+// Number for Type.number. It returns nil when the type is only known at runtime.
+func typePatternType(pattern ast.TypePattern, env *types.TypeEnvironment) *types.Type {
+	typ := resolveType(pattern.Type, env)
+	if typ == nil || typ.Base != types.TYPE {
+		return nil
+	}
+	return typ.Names
+}
+
+// joinOr joins what a case does not match into a list like "Text, Table or Error".
+func joinOr(values []string) string {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
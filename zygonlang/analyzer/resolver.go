@@ -0,0 +1,476 @@
+package analyzer
+
+import (
+	"slices"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/types"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+// scope is the global scope, the scope of a function call or the scope of a case arm.
+// Names are declared in a scope before any code in it is resolved, so code can use
+// a name that is set after it.
+type scope struct {
+	global bool
+	slots  map[string]int
+	outer  *scope
+}
+
+func newScope(outer *scope) *scope {
+	// slot 0 is for _
+	return &scope{slots: map[string]int{"_": 0}, outer: outer}
+}
+
+func (s *scope) add(name string) int {
+	if slot, ok := s.slots[name]; ok {
+		return slot
+	}
+	s.slots[name] = len(s.slots)
+	return s.slots[name]
+}
+
+// resolver fills in where the names of a program are kept.
+type resolver struct {
+	scope *scope
+	// known are the names of the global scope declared by earlier programs
+	known *types.TypeEnvironment
+	// hasLast tells which scopes had _ set in them, the rest get it from outside
+	hasLast map[*scope]bool
+}
+
+// resolveNames resolves every name used in program and reports the ones that are not
+// declared anywhere. It returns the names the program declares in the global scope.
+func resolveNames(program ast.Program, known *types.TypeEnvironment) (ast.Program, []string) {
+	r := &resolver{scope: &scope{global: true, slots: map[string]int{}}, known: known, hasLast: map[*scope]bool{}}
+	r.declare(ast.Block{Span: program.Span, Body: program.Body})
+	program.Body = r.resolveBody(program.Body)
+
+	globals := []string{}
+	for name := range r.scope.slots {
+		globals = append(globals, name)
+	}
+	return program, globals
+}
+
+// declared reports whether name is declared in the current scope or around it.
+func (r *resolver) declared(name string) bool {
+	for s := r.scope; s != nil; s = s.outer {
+		if _, ok := s.slots[name]; ok && (name != "_" || s.global || r.hasLast[s]) {
+			return true
+		}
+	}
+	_, ok := r.known.Get(name)
+	return ok
+}
+
+// declare declares in the current scope every name that node sets when it runs in it.
+func (r *resolver) declare(node ast.Node) {
+	switch node := node.(type) {
+	case ast.Block:
+		for _, statement := range node.Body {
+			switch statement.(type) {
+			case ast.AssignmentStatement, ast.FunctionDeclaration, ast.UsingStatement, ast.PubStatement:
+			default:
+				r.scope.add("_")
+				r.hasLast[r.scope] = true
+			}
+			r.declare(statement)
+		}
+	case ast.AssignmentStatement:
+		if r.declared(node.Name.Value) {
+			panic(errorAt(node.Name.Span, "Cannot reassign identifier %s", node.Name.Value))
+		}
+		r.scope.add(node.Name.Value)
+		r.declare(node.Value)
+	case ast.FunctionDeclaration:
+		if node.Name != nil {
+			r.scope.add(node.Name.Value)
+		}
+		for _, param := range node.Parameters.Keys() {
+			if paramDefault, _ := node.Parameters.Get(param); paramDefault != nil {
+				r.declare(paramDefault)
+			}
+		}
+	case ast.PubStatement:
+		r.declare(node.Public)
+	case ast.UsingStatement:
+		for _, module := range node.Modules {
+			name := module.Module
+			for {
+				access, ok := name.(ast.AccessOperator)
+				if !ok {
+					break
+				}
+				name = access.Attribute.(ast.Name)
+			}
+			r.scope.add(name.(ast.Identifier).Value)
+			for _, symbol := range module.Symbols {
+				r.scope.add(symbol.Value)
+			}
+		}
+	case ast.CaseExpression:
+		if node.Subject != nil {
+			r.declare(node.Subject)
+		}
+		for _, arm := range node.Cases {
+			r.declare(arm.Pattern)
+		}
+		if node.Default != nil {
+			r.declare(*node.Default)
+		}
+	case ast.PrefixExpression:
+		r.declare(node.Right)
+	case ast.InfixExpression:
+		r.declare(node.Left)
+		r.declare(node.Right)
+	case ast.TextLiteral:
+		for _, part := range node.Parts {
+			r.declare(part)
+		}
+	case ast.FunctionCall:
+		r.declare(node.Fn)
+		for _, arg := range node.Arguments {
+			r.declare(arg.Value)
+		}
+	case ast.TableLiteral:
+		for _, entry := range node.Entries {
+			if entry.Computed != nil {
+				r.declare(*entry.Computed)
+			}
+			r.declare(entry.Value)
+		}
+	case ast.AccessOperator:
+		r.declare(node.Subject)
+		r.declare(node.Attribute)
+	case ast.Grouped:
+		r.declare(node.Value)
+	case ast.RestOperator:
+		if node.Value != nil {
+			r.declare(node.Value)
+		}
+	case ast.LiteralPattern:
+		r.declare(node.Value)
+	case ast.TypePattern:
+		r.declare(node.Type)
+	case ast.TablePattern:
+		for _, entry := range node.Entries {
+			r.declare(entry.Value)
+		}
+	case ast.AlternativePattern:
+		for _, alternative := range node.Alternatives {
+			r.declare(alternative)
+		}
+	}
+}
+
+// use resolves a name that is used as a value.
+func (r *resolver) use(ident ast.Identifier) ast.Identifier {
+	depth := 0
+	for s := r.scope; !s.global; s = s.outer {
+		if slot, ok := s.slots[ident.Value]; ok && (ident.Value != "_" || r.hasLast[s]) {
+			ident.Depth, ident.Slot = depth, slot
+			return ident
+		}
+		depth += 1
+	}
+	if !r.declared(ident.Value) {
+		panic(errorAt(ident.Span, "\"%s\" is not defined", ident.Value))
+	}
+	ident.Depth, ident.Slot = 0, ast.Global
+	return ident
+}
+
+// define resolves a name that is set in the current scope.
+func (r *resolver) define(ident ast.Identifier) ast.Identifier {
+	ident.Depth, ident.Slot = 0, ast.Global
+	if !r.scope.global {
+		ident.Slot = r.scope.add(ident.Value)
+	}
+	return ident
+}
+
+func (r *resolver) resolveBody(body []ast.Node) []ast.Node {
+	resolved := make([]ast.Node, len(body))
+	for i, node := range body {
+		resolved[i] = r.resolve(node)
+	}
+	return resolved
+}
+
+func (r *resolver) resolveBlock(block ast.Block) ast.Block {
+	block.Body = r.resolveBody(block.Body)
+	return block
+}
+
+func (r *resolver) resolveExpression(expression ast.Expression) ast.Expression {
+	if expression == nil {
+		return nil
+	}
+	return r.resolve(expression).(ast.Expression)
+}
+
+func (r *resolver) resolve(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case ast.Identifier:
+		return r.use(node)
+	case ast.TextLiteral:
+		parts := make([]ast.Expression, len(node.Parts))
+		for i, part := range node.Parts {
+			parts[i] = r.resolveExpression(part)
+		}
+		node.Parts = parts
+		return node
+	case ast.PrefixExpression:
+		node.Right = r.resolveExpression(node.Right)
+		return node
+	case ast.InfixExpression:
+		node.Left = r.resolveExpression(node.Left)
+		node.Right = r.resolveExpression(node.Right)
+		return node
+	case ast.Block:
+		return r.resolveBlock(node)
+	case ast.AssignmentStatement:
+		node.Value = r.resolveBlock(node.Value)
+		node.Name = r.define(node.Name)
+		return node
+	case ast.PubStatement:
+		node.Public = r.resolve(node.Public)
+		return node
+	case ast.AccessOperator:
+		node.Subject = r.resolveExpression(node.Subject)
+		// an identifier after the dot is a key, not a name
+		if grouped, ok := node.Attribute.(ast.Grouped); ok {
+			grouped.Value = r.resolveExpression(grouped.Value)
+			node.Attribute = grouped
+		}
+		return node
+	case ast.Grouped:
+		node.Value = r.resolveExpression(node.Value)
+		return node
+	case ast.RestOperator:
+		node.Value = r.resolveExpression(node.Value)
+		return node
+	case ast.FunctionCall:
+		node.Fn = r.resolveExpression(node.Fn)
+		arguments := make([]ast.FunctionCallArgument, len(node.Arguments))
+		for i, arg := range node.Arguments {
+			arg.Value = r.resolveExpression(arg.Value)
+			arguments[i] = arg
+		}
+		node.Arguments = arguments
+		return node
+	case ast.TableLiteral:
+		node.Entries = r.resolveEntries(node.Entries)
+		return node
+	case ast.FunctionDeclaration:
+		return r.resolveFunction(node)
+	case ast.CaseExpression:
+		return r.resolveCase(node)
+	case ast.ChainExpression:
+		return r.resolveChain(node)
+	}
+	return node
+}
+
+func (r *resolver) resolveEntries(entries []ast.TableEntry) []ast.TableEntry {
+	resolved := make([]ast.TableEntry, len(entries))
+	for i, entry := range entries {
+		if entry.Computed != nil {
+			key := r.resolveExpression(*entry.Computed).(ast.Grouped)
+			entry.Computed = &key
+		}
+		entry.Value = r.resolveExpression(entry.Value)
+		resolved[i] = entry
+	}
+	return resolved
+}
+
+func (r *resolver) resolveFunction(node ast.FunctionDeclaration) ast.FunctionDeclaration {
+	// defaults are evaluated where the function is declared
+	parameters := orderedmap.NewOrderedMap[string, ast.Expression]()
+	for _, param := range node.Parameters.Keys() {
+		paramDefault, _ := node.Parameters.Get(param)
+		parameters.Set(param, r.resolveExpression(paramDefault))
+	}
+	node.Parameters = parameters
+	if node.Name != nil {
+		name := r.define(*node.Name)
+		node.Name = &name
+	}
+
+	outer := r.scope
+	r.scope = newScope(outer)
+	for _, param := range node.ParameterNames {
+		if _, ok := r.scope.slots[param.Value]; ok {
+			panic(errorAt(param.Span, "%s is already a parameter", param.Value))
+		}
+		r.scope.add(param.Value)
+	}
+	if node.Rest != nil {
+		if rest, ok := node.Rest.Value.(ast.Identifier); ok {
+			if _, ok := r.scope.slots[rest.Value]; ok {
+				panic(errorAt(rest.Span, "%s is already a parameter", rest.Value))
+			}
+			r.scope.add(rest.Value)
+			rest.Depth, rest.Slot = 0, r.scope.slots[rest.Value]
+			node.Rest = &ast.RestOperator{Span: node.Rest.Span, Value: rest}
+		}
+	}
+	r.declare(node.Body)
+	node.Body = r.resolveBlock(node.Body)
+	node.Locals = len(r.scope.slots)
+	r.scope = outer
+	return node
+}
+
+// resolveCase resolves a case expression. Patterns are evaluated where the case is,
+// except for the names they bind, which are set in the scope of their arm.
+// Guards are evaluated in the scope of their arm.
+func (r *resolver) resolveCase(node ast.CaseExpression) ast.CaseExpression {
+	node.Subject = r.resolveExpression(node.Subject)
+	cases := make([]ast.CaseExpressionCase, len(node.Cases))
+	for i, arm := range node.Cases {
+		outer := r.scope
+		armScope := newScope(outer)
+		patternBinds(arm.Pattern)
+		arm.Pattern = r.resolvePattern(arm.Pattern, armScope)
+
+		r.scope = armScope
+		// the guard is checked before the arm runs, so _ is still the one from outside it
+		arm.Guard = r.resolveExpression(arm.Guard)
+		r.declare(arm.Block)
+		arm.Block = r.resolveBlock(arm.Block)
+		arm.Locals = len(armScope.slots)
+		r.scope = outer
+		cases[i] = arm
+	}
+	node.Cases = cases
+	if node.Default != nil {
+		block := r.resolveBlock(*node.Default)
+		node.Default = &block
+	}
+	return node
+}
+
+// resolvePattern resolves a pattern. The names it binds are set in binds, its literals
+// are evaluated in the current scope.
+func (r *resolver) resolvePattern(pattern ast.Pattern, binds *scope) ast.Pattern {
+	switch pattern := pattern.(type) {
+	case ast.LiteralPattern:
+		pattern.Value = r.resolveExpression(pattern.Value)
+		return pattern
+	case ast.BindingPattern:
+		pattern.Name.Depth, pattern.Name.Slot = 0, binds.add(pattern.Name.Value)
+		return pattern
+	case ast.TypePattern:
+		pattern.Type = r.resolveExpression(pattern.Type)
+		if pattern.Name != nil {
+			name := *pattern.Name
+			name.Depth, name.Slot = 0, binds.add(name.Value)
+			pattern.Name = &name
+		}
+		return pattern
+	case ast.TablePattern:
+		entries := make([]ast.PatternEntry, len(pattern.Entries))
+		for i, entry := range pattern.Entries {
+			entry.Value = r.resolvePattern(entry.Value, binds)
+			entries[i] = entry
+		}
+		pattern.Entries = entries
+		if pattern.Rest != nil && pattern.Rest.Name != nil {
+			name := *pattern.Rest.Name
+			name.Depth, name.Slot = 0, binds.add(name.Value)
+			pattern.Rest = &ast.RestPattern{Span: pattern.Rest.Span, Name: &name}
+		}
+		return pattern
+	case ast.AlternativePattern:
+		alternatives := make([]ast.Pattern, len(pattern.Alternatives))
+		for i, alternative := range pattern.Alternatives {
+			alternatives[i] = r.resolvePattern(alternative, binds)
+		}
+		pattern.Alternatives = alternatives
+		return pattern
+	}
+	return pattern
+}
+
+// patternBinds returns the names a pattern binds. It checks that a pattern does not bind
+// a name twice, and that alternatives bind the same names, so the arm has all of them
+// whichever one matches.
+func patternBinds(pattern ast.Pattern) []string {
+	switch pattern := pattern.(type) {
+	case ast.BindingPattern:
+		return []string{pattern.Name.Value}
+	case ast.TypePattern:
+		if pattern.Name != nil {
+			return []string{pattern.Name.Value}
+		}
+		return nil
+	case ast.TablePattern:
+		names := []string{}
+		keys := map[string]bool{}
+		add := func(name string, span token.Span) {
+			if slices.Contains(names, name) {
+				panic(errorAt(span, "\"%s\" is bound twice in a pattern", name))
+			}
+			names = append(names, name)
+		}
+		for _, entry := range pattern.Entries {
+			if entry.Key != nil {
+				if keys[entry.Key.Value] {
+					panic(errorAt(entry.Key.Span, "the key %s is in the table pattern twice", entry.Key.Value))
+				}
+				keys[entry.Key.Value] = true
+			}
+			for _, name := range patternBinds(entry.Value) {
+				add(name, entry.Value.Range())
+			}
+		}
+		if pattern.Rest != nil && pattern.Rest.Name != nil {
+			add(pattern.Rest.Name.Value, pattern.Rest.Span)
+		}
+		return names
+	case ast.AlternativePattern:
+		bound := make([][]string, len(pattern.Alternatives))
+		for i, alternative := range pattern.Alternatives {
+			bound[i] = patternBinds(alternative)
+		}
+		for i, alternative := range pattern.Alternatives {
+			for _, names := range bound {
+				for _, name := range names {
+					if !slices.Contains(bound[i], name) {
+						panic(errorAt(alternative.Range(), "\"%s\" is not bound by every alternative", name))
+					}
+				}
+			}
+		}
+		return bound[0]
+	}
+	return nil
+}
+
+// resolveChain resolves a chain expression. Its lines run in a scope of their own, which
+// has the names their patterns bind and _, the value of the line before.
+func (r *resolver) resolveChain(node ast.ChainExpression) ast.ChainExpression {
+	outer := r.scope
+	r.scope = newScope(outer)
+	for _, line := range node.Lines {
+		r.declare(line.Value)
+		r.declare(line.Pattern)
+	}
+	lines := make([]ast.ChainLine, len(node.Lines))
+	for i, line := range node.Lines {
+		line.Value = r.resolveExpression(line.Value)
+		patternBinds(line.Pattern)
+		line.Pattern = r.resolvePattern(line.Pattern, r.scope)
+		// the first line gets _ from outside the chain
+		r.hasLast[r.scope] = true
+		lines[i] = line
+	}
+	node.Lines = lines
+	node.Locals = len(r.scope.slots)
+	r.scope = outer
+	return node
+}
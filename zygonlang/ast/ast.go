@@ -3,13 +3,17 @@ package ast
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"thechosenzendro/zygonlang/zygonlang/stream"
 	"thechosenzendro/zygonlang/zygonlang/token"
 
 	"github.com/elliotchance/orderedmap/v2"
 )
 
-type Node interface{}
+// Node is anything in the syntax tree. Every node knows where it is in the source code.
+type Node interface {
+	Range() token.Span
+}
 
 type Expression interface {
 	Node
@@ -22,47 +26,63 @@ type Statement interface {
 }
 
 type Program struct {
+	token.Span
 	Body []Node
 }
 
 type Identifier struct {
+	token.Span
 	Value string
+	// Depth and Slot are filled in by the resolver. A name of a local scope is in slot
+	// Slot of the scope Depth scopes out from where it is used. A name of the global
+	// scope is looked up by its value and has a Slot of Global.
+	Depth int
+	Slot  int
 }
 
+// Global is the Slot of an identifier that names something in the global scope.
+const Global = -1
+
 func (Identifier) Expr() {}
 func (Identifier) Name() {}
 
 type NumberLiteral struct {
+	token.Span
 	Value float64
 }
 
 func (NumberLiteral) Expr() {}
 
 type BooleanLiteral struct {
+	token.Span
 	Value bool
 }
 
 func (BooleanLiteral) Expr() {}
 
 type TextLiteral struct {
+	token.Span
 	Parts []Expression
 }
 
 func (TextLiteral) Expr() {}
 
 type TextPart struct {
+	token.Span
 	Value string
 }
 
 func (TextPart) Expr() {}
 
 type PubStatement struct {
+	token.Span
 	Public Node
 }
 
 func (PubStatement) Stmt() {}
 
 type AssignmentStatement struct {
+	token.Span
 	Name  Identifier
 	Value Block
 }
@@ -70,27 +90,128 @@ type AssignmentStatement struct {
 func (AssignmentStatement) Stmt() {}
 
 type CaseExpression struct {
+	token.Span
 	Subject Expression
 	Cases   []CaseExpressionCase
 	Default *Block
 }
 
 type CaseExpressionCase struct {
-	Pattern Expression
-	Block   Block
+	token.Span
+	// Pattern is matched against the subject. In a case without a subject it is a
+	// LiteralPattern, or alternatives of them, whose value is a condition.
+	Pattern Pattern
+	// Guard is the condition after if, checked with the names the pattern binds once it
+	// matches. The arm is not taken when it is false. It is nil when there is none.
+	Guard Expression
+	Block Block
+	// Locals is the number of slots in the scope of the arm, filled in by the resolver.
+	// Slot 0 holds _.
+	Locals int
 }
 
 func (CaseExpression) Expr() {}
 
+// ChainExpression runs its lines one after another for as long as the value of each line
+// matches its pattern. Its value is the value of the first line that does not match,
+// or of the last line.
+type ChainExpression struct {
+	token.Span
+	Lines []ChainLine
+	// Locals is the number of slots in the scope of the chain, filled in by the resolver.
+	// Slot 0 holds _, the value of the line before.
+	Locals int
+}
+
+type ChainLine struct {
+	token.Span
+	Value   Expression
+	Pattern Pattern
+}
+
+func (ChainExpression) Expr() {}
+
+// Pattern is what a value is matched against, in an arm of a case or a line of a chain.
+type Pattern interface {
+	Node
+	Pat()
+}
+
+// LiteralPattern matches a value equal to the value of its expression, which is evaluated
+// where the pattern is.
+type LiteralPattern struct {
+	token.Span
+	Value Expression
+}
+
+// BindingPattern matches any value and binds its name to it. Names in a table pattern are bindings,
+// anywhere else they are literals.
+type BindingPattern struct {
+	token.Span
+	Name Identifier
+}
+
+// WildcardPattern matches any value without binding it, it is written _.
+type WildcardPattern struct {
+	token.Span
+}
+
+// TablePattern matches a table with an entry for every one of its entries, whose value matches
+// the pattern of the entry. The table cannot have any other entries, unless there is a rest.
+type TablePattern struct {
+	token.Span
+	Entries []PatternEntry
+	Rest    *RestPattern
+}
+
+// PatternEntry is an entry of a table pattern. Entries without a key are matched by position.
+type PatternEntry struct {
+	token.Span
+	Key   *Identifier
+	Value Pattern
+}
+
+// RestPattern is the last entry of a table pattern, written ...name, which takes the entries
+// no other entry matched. Its Name is nil when it is written ... and binds nothing.
+type RestPattern struct {
+	token.Span
+	Name *Identifier
+}
+
+// TypePattern matches a value of the type its expression evaluates to, and binds its name
+// to the value. It is written Type.number n, and binds nothing when the name is _.
+type TypePattern struct {
+	token.Span
+	Type Expression
+	Name *Identifier
+}
+
+// AlternativePattern matches a value when one of its alternatives does, they are tried in turn.
+type AlternativePattern struct {
+	token.Span
+	Alternatives []Pattern
+}
+
+func (LiteralPattern) Pat()     {}
+func (BindingPattern) Pat()     {}
+func (WildcardPattern) Pat()    {}
+func (TablePattern) Pat()       {}
+func (RestPattern) Pat()        {}
+func (TypePattern) Pat()        {}
+func (AlternativePattern) Pat() {}
+
 type Block struct {
+	token.Span
 	Body []Node
 }
 
 type UsingStatement struct {
+	token.Span
 	Modules []Module
 }
 
 type Module struct {
+	token.Span
 	Module  Name
 	Symbols []Identifier
 }
@@ -98,6 +219,7 @@ type Module struct {
 func (UsingStatement) Stmt() {}
 
 type PrefixExpression struct {
+	token.Span
 	Operator string
 	Right    Expression
 }
@@ -105,28 +227,39 @@ type PrefixExpression struct {
 func (PrefixExpression) Expr() {}
 
 type InfixExpression struct {
-	Left     Expression
-	Operator string
-	Right    Expression
+	token.Span
+	Left         Expression
+	Operator     string
+	OperatorSpan token.Span
+	Right        Expression
 }
 
 func (InfixExpression) Expr() {}
 
 type FunctionDeclaration struct {
-	Name       *Identifier
-	Parameters *orderedmap.OrderedMap[Identifier, Expression]
-	Rest       *RestOperator
-	Body       Block
+	token.Span
+	Name *Identifier
+	// Parameters are the defaults of the parameters by their names, nil for the ones without one
+	Parameters *orderedmap.OrderedMap[string, Expression]
+	// ParameterNames are the names of the parameters as they are written, in order
+	ParameterNames []Identifier
+	Rest           *RestOperator
+	Body           Block
+	// Locals is the number of slots in the scope of a call, filled in by the resolver.
+	// Slot 0 holds _, the parameters come after it in order, followed by the rest parameter.
+	Locals int
 }
 
 func (FunctionDeclaration) Expr() {}
 
 type FunctionCallArgument struct {
+	token.Span
 	Name  *Identifier
 	Value Expression
 }
 
 type FunctionCall struct {
+	token.Span
 	Fn        Expression
 	Arguments []FunctionCallArgument
 }
@@ -134,22 +267,28 @@ type FunctionCall struct {
 func (FunctionCall) Expr() {}
 
 type TableEntry struct {
-	Key   *Identifier
-	Value Expression
+	token.Span
+	Key *Identifier
+	// Computed is the key of an entry written as .(key): value, which can be any value
+	Computed *Grouped
+	Value    Expression
 }
 type TableLiteral struct {
+	token.Span
 	Entries []TableEntry
 }
 
 func (TableLiteral) Expr() {}
 
 type Grouped struct {
+	token.Span
 	Value Expression
 }
 
 func (Grouped) Expr() {}
 
 type AccessOperator struct {
+	token.Span
 	Subject   Expression
 	Attribute Expression
 }
@@ -158,6 +297,7 @@ func (AccessOperator) Expr() {}
 func (AccessOperator) Name() {}
 
 type RestOperator struct {
+	token.Span
 	Value Expression
 }
 
@@ -168,8 +308,83 @@ type Name interface {
 	Name()
 }
 
-var prefixParsers = map[token.TokenType]func(*stream.Stream[token.Token]) Expression{}
-var infixParsers = map[token.TokenType]func(*stream.Stream[token.Token], Expression) Expression{}
+// ParseError is a problem with the structure of the source code.
+type ParseError struct {
+	token.Span
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Start.Line, e.Start.Column, e.Message)
+}
+func (e *ParseError) Msg() string { return e.Message }
+
+func errorAt(span token.Span, format string, args ...any) *ParseError {
+	return &ParseError{Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorList is every syntax error found in a file, in the order they were found.
+type ErrorList []*ParseError
+
+func (l ErrorList) Error() string {
+	messages := []string{}
+	for _, err := range l {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (l ErrorList) Unwrap() []error {
+	errs := []error{}
+	for _, err := range l {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Parser turns a token stream into a Program. Each Parser has its own state,
+// so different files can be parsed at the same time.
+type Parser struct {
+	tokens        *stream.Stream[token.Token]
+	prefixParsers map[token.TokenType]func() Expression
+	infixParsers  map[token.TokenType]func(Expression) Expression
+	parsingCase   bool
+	errors        ErrorList
+}
+
+func NewParser(tokens *stream.Stream[token.Token]) *Parser {
+	p := &Parser{
+		tokens:        tokens,
+		prefixParsers: map[token.TokenType]func() Expression{},
+		infixParsers:  map[token.TokenType]func(Expression) Expression{},
+		errors:        ErrorList{},
+	}
+	p.prefixParsers[token.IDENT] = p.parseIdentifier
+	p.prefixParsers[token.NUM] = p.parseNumberLiteral
+	p.prefixParsers[token.NOT] = p.parsePrefixExpression
+	p.prefixParsers[token.MINUS] = p.parsePrefixExpression
+	p.prefixParsers[token.TRUE] = p.parseBooleanLiteral
+	p.prefixParsers[token.FALSE] = p.parseBooleanLiteral
+	p.prefixParsers[token.LPAREN] = p.resolveLParen
+	p.prefixParsers[token.CASE] = p.parseCaseExpression
+	p.prefixParsers[token.CHAIN] = p.parseChainExpression
+	p.prefixParsers[token.TEXT_START] = p.parseTextLiteral
+	p.prefixParsers[token.LBRACE] = p.parseTableLiteral
+	p.prefixParsers[token.REST] = p.parseRestOperator
+
+	p.infixParsers[token.PLUS] = p.parseInfixExpression
+	p.infixParsers[token.MINUS] = p.parseInfixExpression
+	p.infixParsers[token.STAR] = p.parseInfixExpression
+	p.infixParsers[token.SLASH] = p.parseInfixExpression
+	p.infixParsers[token.IS] = p.parseIsExpression
+	p.infixParsers[token.GREATER_THAN] = p.parseInfixExpression
+	p.infixParsers[token.LESSER_THAN] = p.parseInfixExpression
+	p.infixParsers[token.AND] = p.parseInfixExpression
+	p.infixParsers[token.OR] = p.parseInfixExpression
+	p.infixParsers[token.LPAREN] = p.parseFunction
+	p.infixParsers[token.DOT] = p.parseAccessOperator
+	return p
+}
 
 const (
 	_ int = iota
@@ -206,99 +421,194 @@ func getPrecedence(token token.Token) int {
 	return LOWEST
 }
 
-func Parse(tokens *stream.Stream[token.Token]) Program {
+// ModuleName returns the name of a module with the names of the directories it is in,
+// like HTTP.Server.
+func ModuleName(module Name) string {
+	switch mod := module.(type) {
+	case Identifier:
+		return mod.Value
+	case AccessOperator:
+		return mod.Subject.(Identifier).Value + "." + ModuleName(mod.Attribute.(Name))
+	}
+	return ""
+}
+
+// SubjectName returns how the subject of an access operator is called in messages,
+// its name in quotes when it is a name and "the table" otherwise.
+func SubjectName(subject Expression) string {
+	if ident, ok := subject.(Identifier); ok {
+		return fmt.Sprintf("\"%s\"", ident.Value)
+	}
+	return "the table"
+}
+
+// spanBetween returns a span starting where start starts and ending where end ends.
+func spanBetween(start token.Span, end token.Span) token.Span {
+	return token.Span{Start: start.Start, End: end.End}
+}
+
+// Parse parses tokens with a new Parser.
+func Parse(tokens *stream.Stream[token.Token]) (Program, error) {
+	return NewParser(tokens).Parse()
+}
+
+// Parse parses the whole token stream. When there are syntax errors it still returns
+// every statement it could parse, together with an ErrorList of the errors.
+func (p *Parser) Parse() (Program, error) {
+	tokens := p.tokens
 	program := Program{Body: []Node{}}
-	prefixParsers[token.IDENT] = parseIdentifier
-	prefixParsers[token.NUM] = parseNumberLiteral
-	prefixParsers[token.NOT] = parsePrefixExpression
-	prefixParsers[token.MINUS] = parsePrefixExpression
-	prefixParsers[token.TRUE] = parseBooleanLiteral
-	prefixParsers[token.FALSE] = parseBooleanLiteral
-	prefixParsers[token.LPAREN] = resolveLParen
-	prefixParsers[token.CASE] = parseCaseExpression
-	prefixParsers[token.TEXT_START] = parseTextLiteral
-	prefixParsers[token.LBRACE] = parseTableLiteral
-	prefixParsers[token.REST] = parseRestOperator
-
-	infixParsers[token.PLUS] = parseInfixExpression
-	infixParsers[token.MINUS] = parseInfixExpression
-	infixParsers[token.STAR] = parseInfixExpression
-	infixParsers[token.SLASH] = parseInfixExpression
-	infixParsers[token.IS] = parseIsExpression
-	infixParsers[token.GREATER_THAN] = parseInfixExpression
-	infixParsers[token.LESSER_THAN] = parseInfixExpression
-	infixParsers[token.AND] = parseInfixExpression
-	infixParsers[token.OR] = parseInfixExpression
-	infixParsers[token.LPAREN] = parseFunction
-	infixParsers[token.DOT] = parseAccessOperator
+	if len(tokens.Contents) > 0 {
+		program.Span = spanBetween(tokens.Contents[0].Span, tokens.Contents[len(tokens.Contents)-1].Span)
+	}
 	for tokens.Peek(0).Type != token.EOF {
 		if tokens.Peek(0).Type != token.EOL {
 			var node Node = nil
-			if token.IsToken(tokens, token.IDENT, 0) && token.IsToken(tokens, token.COLON, 1) {
-				node = parseAssignmentStatement(tokens)
-			} else if token.IsToken(tokens, token.USING, 0) {
-				node = parseUsingStatement(tokens)
-			} else if token.IsToken(tokens, token.PUB, 0) {
-				node = parsePubStatement(tokens)
-			} else {
-				node = parseExpression(tokens, LOWEST)
+			if p.tryParse(func() { node = p.parseStatement() }) {
+				program.Body = append(program.Body, node)
 			}
-			program.Body = append(program.Body, node)
 		}
 		tokens.Consume(1)
 	}
-	return program
+	if len(p.errors) > 0 {
+		return program, p.errors
+	}
+	return program, nil
+}
+
+// tryParse runs parse and reports whether it succeeded. When parse runs into a
+// syntax error, the error is recorded and the tokens are skipped to the end of the
+// statement it was in, so that parsing can go on and find more errors.
+func (p *Parser) tryParse(parse func()) (ok bool) {
+	start := p.tokens.Index
+	defer func() {
+		if r := recover(); r != nil {
+			err, isParseErr := r.(*ParseError)
+			if !isParseErr {
+				panic(r)
+			}
+			// an error at the end of file is found again by every block around it
+			if len(p.errors) == 0 || *p.errors[len(p.errors)-1] != *err {
+				p.errors = append(p.errors, err)
+			}
+			p.synchronize(start)
+			ok = false
+		}
+	}()
+	parse()
+	return true
+}
+
+// synchronize skips tokens up to the last token of the statement that starts at
+// start, together with every block nested in it.
+func (p *Parser) synchronize(start int) {
+	tokens := p.tokens
+	depth := 0
+	for _, tok := range tokens.Contents[start:tokens.Index] {
+		if tok.Type == token.INDENT {
+			depth += 1
+		} else if tok.Type == token.DEDENT {
+			depth -= 1
+		}
+	}
+	end := len(tokens.Contents) - 2
+	for i := tokens.Index; i < len(tokens.Contents); i++ {
+		tok := tokens.Contents[i]
+		if tok.Type == token.INDENT {
+			depth += 1
+		} else if tok.Type == token.DEDENT {
+			depth -= 1
+			if depth == 0 {
+				end = i
+				break
+			} else if depth < 0 {
+				end = i - 1
+				break
+			}
+		} else if tok.Type == token.EOL && depth <= 0 && !token.IsToken(tokens, token.INDENT, i+1-tokens.Index) {
+			end = i
+			break
+		} else if tok.Type == token.EOF {
+			end = i - 1
+			break
+		}
+	}
+	// always move past the token that could not be parsed
+	tokens.Index = max(end, start)
+}
+
+func (p *Parser) parseStatement() Node {
+	tokens := p.tokens
+	if token.IsToken(tokens, token.IDENT, 0) && token.IsToken(tokens, token.COLON, 1) {
+		return p.parseAssignmentStatement()
+	} else if token.IsToken(tokens, token.USING, 0) {
+		return p.parseUsingStatement()
+	} else if token.IsToken(tokens, token.PUB, 0) {
+		return p.parsePubStatement()
+	} else {
+		return p.parseExpression(LOWEST)
+	}
 }
 
-func parseGroupedExpression(tokens *stream.Stream[token.Token]) Expression {
+func (p *Parser) parseGroupedExpression() Expression {
+	tokens := p.tokens
 	tokens.Consume(1)
-	expr := parseExpression(tokens, LOWEST)
+	expr := p.parseExpression(LOWEST)
 	if tokens.Peek(1).Type != token.RPAREN {
-		panic("no rparen")
+		panic(errorAt(tokens.Peek(0).Span, "expected ) after this"))
 	}
 	tokens.Consume(1)
 	return expr
 }
 
-func parseRestOperator(tokens *stream.Stream[token.Token]) Expression {
-	expr := RestOperator{}
+func (p *Parser) parseRestOperator() Expression {
+	tokens := p.tokens
+	expr := RestOperator{Span: tokens.Peek(0).Span}
 	if token.IsToken(tokens, token.RBRACE, 1) || token.IsToken(tokens, token.RPAREN, 1) || token.IsToken(tokens, token.EOL, 1) {
 		return expr
 	}
 	tokens.Consume(1)
-	expr.Value = parseExpression(tokens, LOWEST)
+	expr.Value = p.parseExpression(LOWEST)
+	expr.Span = spanBetween(expr.Span, expr.Value.Range())
 	// ...tokens, ...{}, ...(get(x))
 	return expr
 }
 
-func parseAccessOperator(tokens *stream.Stream[token.Token], left Expression) Expression {
+func (p *Parser) parseAccessOperator(left Expression) Expression {
+	tokens := p.tokens
 	tokens.Consume(1)
 	if tokens.Peek(0).Type == token.IDENT {
-		return AccessOperator{Subject: left, Attribute: parseIdentifier(tokens)}
+		attribute := p.parseIdentifier()
+		return AccessOperator{Span: spanBetween(left.Range(), attribute.Range()), Subject: left, Attribute: attribute}
 	} else if tokens.Peek(0).Type == token.LPAREN {
-		return AccessOperator{Subject: left, Attribute: Grouped{parseGroupedExpression(tokens)}}
+		start := tokens.Peek(0).Span
+		grouped := Grouped{Value: p.parseGroupedExpression()}
+		grouped.Span = spanBetween(start, tokens.Peek(0).Span)
+		return AccessOperator{Span: spanBetween(left.Range(), grouped.Span), Subject: left, Attribute: grouped}
 	}
-	panic(fmt.Sprintf("expected an IDENT or LPAREN, not %s", tokens.Peek(0).Type))
+	panic(errorAt(tokens.Peek(0).Span, "expected an IDENT or LPAREN, not %s", tokens.Peek(0).Type))
 }
 
-func resolveLParen(tokens *stream.Stream[token.Token]) Expression {
+func (p *Parser) resolveLParen() Expression {
+	tokens := p.tokens
 	i := 0
 	parenLevel := tokens.Peek(0).Value
 
 	for !(token.IsToken(tokens, token.RPAREN, i) && tokens.Peek(i).Value == parenLevel) {
+		if tokens.Peek(i) == nil {
+			panic(errorAt(tokens.Peek(0).Span, "this ( is never closed"))
+		}
 		i += 1
 	}
 	i += 1
 	if token.IsToken(tokens, token.COLON, i) {
-		return parseFunction(tokens, nil)
+		return p.parseFunction(nil)
 	} else {
-		return parseGroupedExpression(tokens)
+		return p.parseGroupedExpression()
 	}
 }
 
-var parsingCase = false
-
-func parseFunction(tokens *stream.Stream[token.Token], fn Expression) Expression {
+func (p *Parser) parseFunction(fn Expression) Expression {
+	tokens := p.tokens
 	isDeclaration := false
 	if fn == nil {
 		isDeclaration = true
@@ -307,16 +617,20 @@ func parseFunction(tokens *stream.Stream[token.Token], fn Expression) Expression
 		parenLevel := tokens.Peek(0).Value
 
 		for !(token.IsToken(tokens, token.RPAREN, i) && tokens.Peek(i).Value == parenLevel) {
+			if tokens.Peek(i) == nil {
+				panic(errorAt(tokens.Peek(0).Span, "this ( is never closed"))
+			}
 			i += 1
 		}
 		i += 1
-		if token.IsToken(tokens, token.COLON, i) && !parsingCase {
+		if token.IsToken(tokens, token.COLON, i) && !p.parsingCase {
 			isDeclaration = true
 		}
 	}
 	if isDeclaration {
-		expr := FunctionDeclaration{Parameters: orderedmap.NewOrderedMap[Identifier, Expression]()}
+		expr := FunctionDeclaration{Span: tokens.Peek(0).Span, Parameters: orderedmap.NewOrderedMap[string, Expression]()}
 		if fn != nil {
+			expr.Span = spanBetween(fn.Range(), expr.Span)
 			switch fn := fn.(type) {
 			case Identifier:
 				expr.Name = &fn
@@ -328,42 +642,45 @@ func parseFunction(tokens *stream.Stream[token.Token], fn Expression) Expression
 		tokens.Consume(1)
 		for !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
 			if token.IsToken(tokens, token.IDENT, 0) {
-				name := parseIdentifier(tokens).(Identifier)
+				name := p.parseIdentifier().(Identifier)
 				var param_default Expression = nil
 				tokens.Consume(1)
 				if token.IsToken(tokens, token.COLON, 0) {
 					tokens.Consume(1)
-					param_default = parseExpression(tokens, LOWEST)
+					param_default = p.parseExpression(LOWEST)
 					tokens.Consume(1)
 				}
 				if token.IsToken(tokens, token.COMMA, 0) {
 					tokens.Consume(1)
 				} else if !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
-					panic("no comma in function declaration")
+					panic(errorAt(tokens.Peek(0).Span, "no comma in function declaration"))
 				}
-				expr.Parameters.Set(name, param_default)
+				expr.Parameters.Set(name.Value, param_default)
+				expr.ParameterNames = append(expr.ParameterNames, name)
 
 			} else if token.IsToken(tokens, token.REST, 0) {
-				if !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
-					panic("a rest operator must be the last thing in function declaration")
+				if !(token.IsToken(tokens, token.RPAREN, 2) && tokens.Peek(2).Value == parenLevel) {
+					panic(errorAt(tokens.Peek(0).Span, "a rest operator must be the last thing in function declaration"))
 				}
-				rest := parseRestOperator(tokens).(RestOperator)
+				rest := p.parseRestOperator().(RestOperator)
 				expr.Rest = &rest
 				tokens.Consume(1)
 			} else {
-				panic(fmt.Sprintf("Expected an IDENT, not %s", tokens.Peek(0).Type))
+				panic(errorAt(tokens.Peek(0).Span, "Expected an IDENT, not %s", tokens.Peek(0).Type))
 			}
 		}
 		tokens.Consume(1)
 		if !token.IsToken(tokens, token.COLON, 0) {
-			panic("no colon in function declaration")
+			panic(errorAt(tokens.Peek(0).Span, "no colon in function declaration"))
 		}
 		tokens.Consume(1)
-		expr.Body = parseBlock(tokens)
+		expr.Body = p.parseBlock()
+		expr.Span = spanBetween(expr.Span, expr.Body.Span)
 		return expr
 	} else {
 		expr := FunctionCall{}
 		expr.Fn = fn
+		expr.Span = fn.Range()
 
 		parenLevel := tokens.Peek(0).Value
 		tokens.Consume(1)
@@ -371,105 +688,116 @@ func parseFunction(tokens *stream.Stream[token.Token], fn Expression) Expression
 		for !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
 			isNamed := false
 			if token.IsToken(tokens, token.IDENT, 0) {
-				argument := FunctionCallArgument{}
+				argument := FunctionCallArgument{Span: tokens.Peek(0).Span}
 				if token.IsToken(tokens, token.COLON, 1) {
 					onlyNamedNow = true
 					isNamed = true
-					switch name := parseIdentifier(tokens).(type) {
+					switch name := p.parseIdentifier().(type) {
 					case Identifier:
 						argument.Name = &name
 					}
 					tokens.Consume(2)
 				}
 				if !isNamed && onlyNamedNow {
-					panic("cannot put positional arguments after a named one")
+					panic(errorAt(tokens.Peek(0).Span, "cannot put positional arguments after a named one"))
 				}
-				argument.Value = parseExpression(tokens, LOWEST)
+				argument.Value = p.parseExpression(LOWEST)
+				argument.Span = spanBetween(argument.Span, argument.Value.Range())
 				tokens.Consume(1)
 				if token.IsToken(tokens, token.COMMA, 0) {
 					tokens.Consume(1)
 				} else if !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
-					panic("no comma in function call")
+					panic(errorAt(tokens.Peek(0).Span, "no comma in function call"))
 				}
 				expr.Arguments = append(expr.Arguments, argument)
 			} else {
 				if !isNamed && onlyNamedNow {
-					panic("cannot put positional arguments after a named one")
+					panic(errorAt(tokens.Peek(0).Span, "cannot put positional arguments after a named one"))
 				}
 				argument := FunctionCallArgument{}
 				argument.Name = nil
-				argument.Value = parseExpression(tokens, LOWEST)
+				argument.Value = p.parseExpression(LOWEST)
+				argument.Span = argument.Value.Range()
 				tokens.Consume(1)
 				switch argument.Value.(type) {
 				case RestOperator:
 					if !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
-						panic("a rest operator must be the last thing in function call")
+						panic(errorAt(tokens.Peek(0).Span, "a rest operator must be the last thing in function call"))
 					}
 				}
 				if token.IsToken(tokens, token.COMMA, 0) {
 					tokens.Consume(1)
 				} else if !(token.IsToken(tokens, token.RPAREN, 0) && tokens.Peek(0).Value == parenLevel) {
-					panic("no comma in function call")
+					panic(errorAt(tokens.Peek(0).Span, "no comma in function call"))
 				}
 				expr.Arguments = append(expr.Arguments, argument)
 			}
 		}
+		expr.Span = spanBetween(expr.Span, tokens.Peek(0).Span)
 		return expr
 	}
 }
-func parseAssignmentStatement(tokens *stream.Stream[token.Token]) Statement {
+func (p *Parser) parseAssignmentStatement() Statement {
+	tokens := p.tokens
 	stmt := AssignmentStatement{}
-	stmt.Name = parseIdentifier(tokens).(Identifier)
+	stmt.Name = p.parseIdentifier().(Identifier)
 
 	tokens.Consume(1)
 
 	if !token.IsToken(tokens, token.COLON, 0) {
-		panic("no colon in assignment statement")
+		panic(errorAt(tokens.Peek(0).Span, "no colon in assignment statement"))
 	}
 
 	tokens.Consume(1)
 
-	stmt.Value = parseBlock(tokens)
+	stmt.Value = p.parseBlock()
+	stmt.Span = spanBetween(stmt.Name.Span, stmt.Value.Span)
 
 	return stmt
 }
 
-func parsePubStatement(tokens *stream.Stream[token.Token]) Statement {
-	stmt := PubStatement{}
+func (p *Parser) parsePubStatement() Statement {
+	tokens := p.tokens
+	stmt := PubStatement{Span: tokens.Peek(0).Span}
 	tokens.Consume(1)
 	if token.IsToken(tokens, token.IDENT, 0) && token.IsToken(tokens, token.COLON, 1) {
-		stmt.Public = parseAssignmentStatement(tokens)
+		stmt.Public = p.parseAssignmentStatement()
 	} else {
-		stmt.Public = parseExpression(tokens, LOWEST)
+		stmt.Public = p.parseExpression(LOWEST)
 	}
+	stmt.Span = spanBetween(stmt.Span, stmt.Public.Range())
 	return stmt
 }
 
-func parseUsingPath(tokens *stream.Stream[token.Token]) Name {
+func (p *Parser) parseUsingPath() Name {
+	tokens := p.tokens
 	if token.IsToken(tokens, token.IDENT, 0) {
 		if (token.IsToken(tokens, token.DOT, 1) && token.IsToken(tokens, token.LPAREN, 2)) || (token.IsToken(tokens, token.EOL, 1) || token.IsToken(tokens, token.COMMA, 1)) {
-			return Identifier{tokens.Peek(0).Value}
+			return Identifier{Span: tokens.Peek(0).Span, Value: tokens.Peek(0).Value}
 		} else if token.IsToken(tokens, token.DOT, 1) {
 			if token.IsToken(tokens, token.IDENT, 2) {
-				subject := Identifier{tokens.Peek(0).Value}
+				subject := Identifier{Span: tokens.Peek(0).Span, Value: tokens.Peek(0).Value}
 				tokens.Consume(2)
-				return AccessOperator{Subject: subject, Attribute: parseUsingPath(tokens)}
+				attribute := p.parseUsingPath()
+				return AccessOperator{Span: spanBetween(subject.Span, attribute.Range()), Subject: subject, Attribute: attribute}
 			} else {
-				panic("expected an IDENT or ( after this")
+				panic(errorAt(tokens.Peek(1).Span, "expected an IDENT or ( after this"))
 			}
 		}
 	}
-	panic("")
+	panic(errorAt(tokens.Peek(0).Span, "expected a name of a module, not %s", tokens.Peek(0).Type))
 }
 
-func parseUsingStatement(tokens *stream.Stream[token.Token]) Statement {
+func (p *Parser) parseUsingStatement() Statement {
+	tokens := p.tokens
+	stmt := UsingStatement{Span: tokens.Peek(0).Span}
 	tokens.Consume(1)
-	stmt := UsingStatement{}
 	for {
 		if token.IsToken(tokens, token.EOL, 0) {
 			break
 		} else if token.IsToken(tokens, token.IDENT, 0) {
-			mod := Module{Module: parseUsingPath(tokens)}
+			mod := Module{Module: p.parseUsingPath()}
+			mod.Span = mod.Module.Range()
 			tokens.Consume(1)
 			endsWithDot := false
 			if token.IsToken(tokens, token.DOT, 0) {
@@ -479,7 +807,7 @@ func parseUsingStatement(tokens *stream.Stream[token.Token]) Statement {
 			if endsWithDot {
 				tokens.Consume(1)
 				if !token.IsToken(tokens, token.LPAREN, 0) {
-					panic("no left paren in using")
+					panic(errorAt(tokens.Peek(0).Span, "no left paren in using"))
 				}
 
 				tokens.Consume(1)
@@ -487,23 +815,25 @@ func parseUsingStatement(tokens *stream.Stream[token.Token]) Statement {
 					if token.IsToken(tokens, token.RPAREN, 0) {
 						break
 					} else if token.IsToken(tokens, token.IDENT, 0) {
-						mod.Symbols = append(mod.Symbols, parseIdentifier(tokens).(Identifier))
+						mod.Symbols = append(mod.Symbols, p.parseIdentifier().(Identifier))
+						mod.Span = spanBetween(mod.Span, tokens.Peek(0).Span)
 						tokens.Consume(1)
 						if token.IsToken(tokens, token.COMMA, 0) {
 							tokens.Consume(1)
 						} else if token.IsToken(tokens, token.RPAREN, 0) {
 
 						} else {
-							panic(fmt.Sprintf("expected a COMMA or a newline, not %s", tokens.Peek(0).Type))
+							panic(errorAt(tokens.Peek(0).Span, "expected a COMMA or a newline, not %s", tokens.Peek(0).Type))
 						}
 					} else if token.IsToken(tokens, token.EOL, 0) {
 						tokens.Consume(1)
 					} else if token.IsToken(tokens, token.EOF, 0) {
-						panic("unexpected end of .()")
+						panic(errorAt(tokens.Peek(0).Span, "unexpected end of .()"))
 					} else {
-						panic(fmt.Sprintf("expected a module name, not %s", tokens.Peek(0).Type))
+						panic(errorAt(tokens.Peek(0).Span, "expected a module name, not %s", tokens.Peek(0).Type))
 					}
 				}
+				mod.Span = spanBetween(mod.Span, tokens.Peek(0).Span)
 				tokens.Consume(1)
 			}
 			if token.IsToken(tokens, token.COMMA, 0) {
@@ -511,28 +841,30 @@ func parseUsingStatement(tokens *stream.Stream[token.Token]) Statement {
 			} else if token.IsToken(tokens, token.EOL, 0) {
 
 			} else {
-				panic(fmt.Sprintf("expected a COMMA or a newline, not %s", tokens.Peek(0).Type))
+				panic(errorAt(tokens.Peek(0).Span, "expected a COMMA or a newline, not %s", tokens.Peek(0).Type))
 			}
 			stmt.Modules = append(stmt.Modules, mod)
+			stmt.Span = spanBetween(stmt.Span, mod.Span)
 		} else {
-			panic(fmt.Sprintf("expected a name of a module, not %s", tokens.Peek(0).Type))
+			panic(errorAt(tokens.Peek(0).Span, "expected a name of a module, not %s", tokens.Peek(0).Type))
 		}
 
 	}
 	if len(stmt.Modules) == 0 {
-		panic("expected a name of a module after this")
+		panic(errorAt(stmt.Span, "expected a name of a module after this"))
 	}
 	return stmt
 }
 
-func parseTableLiteral(tokens *stream.Stream[token.Token]) Expression {
-	expr := TableLiteral{}
+func (p *Parser) parseTableLiteral() Expression {
+	tokens := p.tokens
+	expr := TableLiteral{Span: tokens.Peek(0).Span}
 	braceLevel := tokens.Peek(0).Value
 	tokens.Consume(1)
 	for !(token.IsToken(tokens, token.RBRACE, 0) && tokens.Peek(0).Value == braceLevel) {
-		entry := TableEntry{}
+		entry := TableEntry{Span: tokens.Peek(0).Span}
 		if token.IsToken(tokens, token.IDENT, 0) {
-			val := parseIdentifier(tokens)
+			val := p.parseIdentifier()
 
 			tokens.Consume(1)
 
@@ -542,7 +874,8 @@ func parseTableLiteral(tokens *stream.Stream[token.Token]) Expression {
 					entry.Key = &key
 				}
 				tokens.Consume(1)
-				entry.Value = parseExpression(tokens, LOWEST)
+				entry.Value = p.parseExpression(LOWEST)
+				entry.Span = spanBetween(entry.Span, entry.Value.Range())
 				tokens.Consume(1)
 				expr.Entries = append(expr.Entries, entry)
 			} else {
@@ -550,40 +883,62 @@ func parseTableLiteral(tokens *stream.Stream[token.Token]) Expression {
 				entry.Value = val
 				expr.Entries = append(expr.Entries, entry)
 			}
+		} else if token.IsToken(tokens, token.DOT, 0) {
+			tokens.Consume(1)
+			if !token.IsToken(tokens, token.LPAREN, 0) {
+				panic(errorAt(tokens.Peek(0).Span, "expected an LPAREN, not %s", tokens.Peek(0).Type))
+			}
+			start := tokens.Peek(0).Span
+			key := Grouped{Value: p.parseGroupedExpression()}
+			key.Span = spanBetween(start, tokens.Peek(0).Span)
+			entry.Computed = &key
+			if !token.IsToken(tokens, token.COLON, 1) {
+				panic(errorAt(tokens.Peek(0).Span, "expected : after this"))
+			}
+			tokens.Consume(2)
+			entry.Value = p.parseExpression(LOWEST)
+			entry.Span = spanBetween(entry.Span, entry.Value.Range())
+			tokens.Consume(1)
+			expr.Entries = append(expr.Entries, entry)
 		} else if token.IsToken(tokens, token.COMMA, 0) {
 			tokens.Consume(1)
 		} else if token.IsToken(tokens, token.EOL, 0) || token.IsToken(tokens, token.INDENT, 0) || token.IsToken(tokens, token.DEDENT, 0) {
 			tokens.Consume(1)
 		} else {
 			entry.Key = nil
-			entry.Value = parseExpression(tokens, LOWEST)
+			entry.Value = p.parseExpression(LOWEST)
+			entry.Span = spanBetween(entry.Span, entry.Value.Range())
 			tokens.Consume(1)
 
 			expr.Entries = append(expr.Entries, entry)
 		}
 	}
+	expr.Span = spanBetween(expr.Span, tokens.Peek(0).Span)
 	return expr
 }
 
-func parseCaseExpression(tokens *stream.Stream[token.Token]) Expression {
-	expr := CaseExpression{}
-	parsingCase = true
+func (p *Parser) parseCaseExpression() Expression {
+	tokens := p.tokens
+	expr := CaseExpression{Span: tokens.Peek(0).Span}
+	outerParsingCase := p.parsingCase
+	p.parsingCase = true
+	defer func() { p.parsingCase = outerParsingCase }()
 	tokens.Consume(1)
 	if !token.IsToken(tokens, token.COLON, 0) {
-		expr.Subject = parseExpression(tokens, LOWEST)
+		expr.Subject = p.parseExpression(LOWEST)
 		tokens.Consume(1)
 	}
 	if !token.IsToken(tokens, token.COLON, 0) {
-		panic("no colon in case expression")
+		panic(errorAt(tokens.Peek(0).Span, "no colon in case expression"))
 	}
 	tokens.Consume(1)
 	if !token.IsToken(tokens, token.EOL, 0) {
-		panic("no newline in case expression")
+		panic(errorAt(tokens.Peek(0).Span, "no newline in case expression"))
 	}
 	tokens.Consume(1)
 
 	if !token.IsToken(tokens, token.INDENT, 0) {
-		panic("case expression must contain indentation")
+		panic(errorAt(tokens.Peek(0).Span, "case expression must contain indentation"))
 	}
 	indentLevel := tokens.Peek(0).Value
 	tokens.Consume(1)
@@ -592,51 +947,250 @@ func parseCaseExpression(tokens *stream.Stream[token.Token]) Expression {
 		if tok.Type == token.DEDENT && tok.Value == indentLevel {
 			break
 		}
-		if token.IsToken(tokens, token.DEFAULT, 0) {
-			tokens.Consume(1)
-			if !token.IsToken(tokens, token.COLON, 0) {
-				panic("no colon in case expression")
-			}
-			tokens.Consume(1)
-			block := parseBlock(tokens)
+		if tok.Type == token.EOF {
+			panic(errorAt(tok.Span, "unexpected end of file in case expression"))
+		}
+		parsed := p.tryParse(func() { p.parseCaseArm(&expr) })
+		if !parsed {
 			tokens.Consume(1)
 			if token.IsToken(tokens, token.EOL, 0) {
 				tokens.Consume(1)
 			}
-			if expr.Default == nil {
-				expr.Default = &block
-			} else {
-				panic("cannot have more than one default in case")
-			}
+		}
+	}
+	return expr
+}
+
+func (p *Parser) parseCaseArm(expr *CaseExpression) {
+	tokens := p.tokens
+	if token.IsToken(tokens, token.DEFAULT, 0) {
+		if expr.Default != nil {
+			panic(errorAt(tokens.Peek(0).Span, "cannot have more than one default in case"))
+		}
+		tokens.Consume(1)
+		if !token.IsToken(tokens, token.COLON, 0) {
+			panic(errorAt(tokens.Peek(0).Span, "no colon in case expression"))
+		}
+		tokens.Consume(1)
+		block := p.parseBlock()
+		expr.Span = spanBetween(expr.Span, block.Span)
+		tokens.Consume(1)
+		if token.IsToken(tokens, token.EOL, 0) {
+			tokens.Consume(1)
+		}
+		expr.Default = &block
+	} else {
+		var pattern Pattern
+		if expr.Subject == nil {
+			pattern = p.parseConditions()
 		} else {
-			pattern := parseExpression(tokens, LOWEST)
+			pattern = p.parsePattern(false)
+		}
+		tokens.Consume(1)
+		var guard Expression
+		if token.IsToken(tokens, token.IF, 0) {
 			tokens.Consume(1)
-			if !token.IsToken(tokens, token.COLON, 0) {
-				panic("no colon in case expression")
-			}
+			guard = p.parseExpression(LOWEST)
+			tokens.Consume(1)
+		}
+		if !token.IsToken(tokens, token.COLON, 0) {
+			panic(errorAt(tokens.Peek(0).Span, "no colon in case expression"))
+		}
+		tokens.Consume(1)
+		block := p.parseBlock()
+		expr.Span = spanBetween(expr.Span, block.Span)
+		tokens.Consume(1)
+		if token.IsToken(tokens, token.EOL, 0) {
 			tokens.Consume(1)
-			block := parseBlock(tokens)
+		}
+		expr.Cases = append(expr.Cases, CaseExpressionCase{Span: spanBetween(pattern.Range(), block.Span), Pattern: pattern, Guard: guard, Block: block})
+	}
+}
+
+func (p *Parser) parseChainExpression() Expression {
+	tokens := p.tokens
+	expr := ChainExpression{Span: tokens.Peek(0).Span}
+	// a line is parsed like the pattern of a case, a call before its colon is not a declaration
+	outerParsingCase := p.parsingCase
+	p.parsingCase = true
+	defer func() { p.parsingCase = outerParsingCase }()
+	tokens.Consume(1)
+	if !token.IsToken(tokens, token.COLON, 0) {
+		panic(errorAt(tokens.Peek(0).Span, "no colon in chain expression"))
+	}
+	tokens.Consume(1)
+	if !token.IsToken(tokens, token.EOL, 0) {
+		panic(errorAt(tokens.Peek(0).Span, "no newline in chain expression"))
+	}
+	tokens.Consume(1)
+
+	if !token.IsToken(tokens, token.INDENT, 0) {
+		panic(errorAt(tokens.Peek(0).Span, "chain expression must contain indentation"))
+	}
+	indentLevel := tokens.Peek(0).Value
+	tokens.Consume(1)
+	for {
+		tok := tokens.Peek(0)
+		if tok.Type == token.DEDENT && tok.Value == indentLevel {
+			break
+		}
+		if tok.Type == token.EOF {
+			panic(errorAt(tok.Span, "unexpected end of file in chain expression"))
+		}
+		parsed := p.tryParse(func() { p.parseChainLine(&expr) })
+		if !parsed {
 			tokens.Consume(1)
 			if token.IsToken(tokens, token.EOL, 0) {
 				tokens.Consume(1)
 			}
-			expr.Cases = append(expr.Cases, CaseExpressionCase{pattern, block})
 		}
 	}
-	parsingCase = false
 	return expr
 }
 
-func parseBlock(tokens *stream.Stream[token.Token]) Block {
+func (p *Parser) parseChainLine(expr *ChainExpression) {
+	tokens := p.tokens
+	val := p.parseExpression(LOWEST)
+	tokens.Consume(1)
+	if !token.IsToken(tokens, token.COLON, 0) {
+		panic(errorAt(tokens.Peek(0).Span, "no colon in chain expression"))
+	}
+	tokens.Consume(1)
+	pattern := p.parsePattern(false)
+	expr.Span = spanBetween(expr.Span, pattern.Range())
+	tokens.Consume(1)
+	if token.IsToken(tokens, token.EOL, 0) {
+		tokens.Consume(1)
+	}
+	expr.Lines = append(expr.Lines, ChainLine{Span: spanBetween(val.Range(), pattern.Range()), Value: val, Pattern: pattern})
+}
+
+// parsePattern parses a pattern with the alternatives after it. A name in a table pattern
+// binds the value it is matched against, anywhere else it is a value to compare it to.
+// A value followed by a name is a type pattern.
+func (p *Parser) parsePattern(inTable bool) Pattern {
+	tokens := p.tokens
+	first := p.parseSinglePattern(inTable)
+	if !token.IsToken(tokens, token.PIPE, 1) {
+		return first
+	}
+	pattern := AlternativePattern{Span: first.Range(), Alternatives: []Pattern{first}}
+	for token.IsToken(tokens, token.PIPE, 1) {
+		tokens.Consume(2)
+		alternative := p.parseSinglePattern(inTable)
+		pattern.Alternatives = append(pattern.Alternatives, alternative)
+		pattern.Span = spanBetween(pattern.Span, alternative.Range())
+	}
+	return pattern
+}
+
+func (p *Parser) parseSinglePattern(inTable bool) Pattern {
+	if token.IsToken(p.tokens, token.LBRACE, 0) {
+		return p.parseTablePattern()
+	}
+	expr := p.parseExpression(LOWEST)
+	if token.IsToken(p.tokens, token.IDENT, 1) {
+		p.tokens.Consume(1)
+		name := p.parseIdentifier().(Identifier)
+		pattern := TypePattern{Span: spanBetween(expr.Range(), name.Span), Type: expr}
+		if name.Value != "_" {
+			pattern.Name = &name
+		}
+		return pattern
+	}
+	if ident, ok := expr.(Identifier); ok {
+		if ident.Value == "_" {
+			return WildcardPattern{Span: ident.Span}
+		}
+		if inTable {
+			return BindingPattern{Span: ident.Span, Name: ident}
+		}
+	}
+	return LiteralPattern{Span: expr.Range(), Value: expr}
+}
+
+func (p *Parser) parseTablePattern() Pattern {
+	tokens := p.tokens
+	pattern := TablePattern{Span: tokens.Peek(0).Span}
+	braceLevel := tokens.Peek(0).Value
+	tokens.Consume(1)
+	for !(token.IsToken(tokens, token.RBRACE, 0) && tokens.Peek(0).Value == braceLevel) {
+		if token.IsToken(tokens, token.COMMA, 0) || token.IsToken(tokens, token.EOL, 0) || token.IsToken(tokens, token.INDENT, 0) || token.IsToken(tokens, token.DEDENT, 0) {
+			tokens.Consume(1)
+			continue
+		}
+		if pattern.Rest != nil {
+			panic(errorAt(pattern.Rest.Span, "a rest has to be the last entry of a table pattern"))
+		}
+		if token.IsToken(tokens, token.DOT, 0) {
+			panic(errorAt(tokens.Peek(0).Span, "table patterns cannot have computed keys"))
+		}
+		if token.IsToken(tokens, token.REST, 0) {
+			rest := RestPattern{Span: tokens.Peek(0).Span}
+			if token.IsToken(tokens, token.IDENT, 1) {
+				tokens.Consume(1)
+				name := p.parseIdentifier().(Identifier)
+				rest.Span = spanBetween(rest.Span, name.Span)
+				if name.Value != "_" {
+					rest.Name = &name
+				}
+			} else if !token.IsToken(tokens, token.RBRACE, 1) && !token.IsToken(tokens, token.COMMA, 1) && !token.IsToken(tokens, token.EOL, 1) {
+				panic(errorAt(tokens.Peek(1).Span, "a rest in a pattern can only have a name"))
+			}
+			tokens.Consume(1)
+			pattern.Rest = &rest
+			continue
+		}
+
+		entry := PatternEntry{Span: tokens.Peek(0).Span}
+		if token.IsToken(tokens, token.IDENT, 0) && token.IsToken(tokens, token.COLON, 1) {
+			key := p.parseIdentifier().(Identifier)
+			entry.Key = &key
+			tokens.Consume(2)
+			if token.IsToken(tokens, token.REST, 0) {
+				panic(errorAt(tokens.Peek(0).Span, "a rest in a pattern cannot have a key"))
+			}
+		}
+		entry.Value = p.parsePattern(true)
+		entry.Span = spanBetween(entry.Span, entry.Value.Range())
+		tokens.Consume(1)
+		pattern.Entries = append(pattern.Entries, entry)
+	}
+	pattern.Span = spanBetween(pattern.Span, tokens.Peek(0).Span)
+	return pattern
+}
+
+// parseConditions parses the pattern of an arm of a case without a subject, which is made
+// of conditions with | between them.
+func (p *Parser) parseConditions() Pattern {
+	tokens := p.tokens
+	expr := p.parseExpression(LOWEST)
+	first := LiteralPattern{Span: expr.Range(), Value: expr}
+	if !token.IsToken(tokens, token.PIPE, 1) {
+		return first
+	}
+	pattern := AlternativePattern{Span: first.Span, Alternatives: []Pattern{first}}
+	for token.IsToken(tokens, token.PIPE, 1) {
+		tokens.Consume(2)
+		expr := p.parseExpression(LOWEST)
+		pattern.Alternatives = append(pattern.Alternatives, LiteralPattern{Span: expr.Range(), Value: expr})
+		pattern.Span = spanBetween(pattern.Span, expr.Range())
+	}
+	return pattern
+}
+
+func (p *Parser) parseBlock() Block {
+	tokens := p.tokens
 	block := Block{}
 	if !token.IsToken(tokens, token.EOL, 0) {
-		expr := parseExpression(tokens, LOWEST)
+		expr := p.parseExpression(LOWEST)
 		block.Body = []Node{expr}
+		block.Span = expr.Range()
 		return block
 	}
 	tokens.Consume(1)
 	if !token.IsToken(tokens, token.INDENT, 0) {
-		panic("expected INDENT or a value after this")
+		panic(errorAt(tokens.Peek(-1).Span, "expected INDENT or a value after this"))
 	}
 	indentLevel := tokens.Peek(0).Value
 	tokens.Consume(1)
@@ -650,100 +1204,113 @@ func parseBlock(tokens *stream.Stream[token.Token]) Block {
 		if tok.Type == token.DEDENT && tok.Value == indentLevel {
 			break
 		}
+		if tok.Type == token.EOF {
+			panic(errorAt(tok.Span, "unexpected end of file in block"))
+		}
 		var node Node = nil
-		if token.IsToken(tokens, token.IDENT, 0) && token.IsToken(tokens, token.COLON, 1) {
-			node = parseAssignmentStatement(tokens)
-		} else if token.IsToken(tokens, token.USING, 0) {
-			node = parseUsingStatement(tokens)
-		} else if token.IsToken(tokens, token.PUB, 0) {
-			node = parsePubStatement(tokens)
-		} else {
-			node = parseExpression(tokens, LOWEST)
+		if p.tryParse(func() { node = p.parseStatement() }) {
+			if len(block.Body) == 0 {
+				block.Span = node.Range()
+			}
+			block.Body = append(block.Body, node)
+			block.Span = spanBetween(block.Span, node.Range())
 		}
-		block.Body = append(block.Body, node)
 		tokens.Consume(1)
 	}
 	return block
 }
 
-func parseBooleanLiteral(tokens *stream.Stream[token.Token]) Expression {
+func (p *Parser) parseBooleanLiteral() Expression {
+	tokens := p.tokens
 	if token.IsToken(tokens, token.TRUE, 0) {
-		return BooleanLiteral{true}
+		return BooleanLiteral{Span: tokens.Peek(0).Span, Value: true}
 	} else {
-		return BooleanLiteral{false}
+		return BooleanLiteral{Span: tokens.Peek(0).Span, Value: false}
 	}
 }
 
-func parseInfixExpression(tokens *stream.Stream[token.Token], left Expression) Expression {
-	expr := InfixExpression{Left: left, Operator: string(tokens.Peek(0).Type)}
+func (p *Parser) parseInfixExpression(left Expression) Expression {
+	tokens := p.tokens
+	expr := InfixExpression{Left: left, Operator: string(tokens.Peek(0).Type), OperatorSpan: tokens.Peek(0).Span}
 	precedence := getPrecedence(*tokens.Peek(0))
 	tokens.Consume(1)
-	expr.Right = parseExpression(tokens, precedence)
+	expr.Right = p.parseExpression(precedence)
+	expr.Span = spanBetween(left.Range(), expr.Right.Range())
 	return expr
 }
 
-func parseIsExpression(tokens *stream.Stream[token.Token], left Expression) Expression {
-	expr := InfixExpression{Left: left, Operator: token.IS}
+func (p *Parser) parseIsExpression(left Expression) Expression {
+	tokens := p.tokens
+	expr := InfixExpression{Left: left, Operator: token.IS, OperatorSpan: tokens.Peek(0).Span}
 	precedence := getPrecedence(*tokens.Peek(0))
 	tokens.Consume(1)
 	if token.IsToken(tokens, token.NOT, 0) {
 		expr.Operator = token.IS_NOT
+		expr.OperatorSpan = spanBetween(expr.OperatorSpan, tokens.Peek(0).Span)
 		tokens.Consume(1)
 	}
-	expr.Right = parseExpression(tokens, precedence)
+	expr.Right = p.parseExpression(precedence)
+	expr.Span = spanBetween(left.Range(), expr.Right.Range())
 	return expr
 }
 
-func parsePrefixExpression(tokens *stream.Stream[token.Token]) Expression {
-	expr := PrefixExpression{Operator: string(tokens.Peek(0).Type)}
+func (p *Parser) parsePrefixExpression() Expression {
+	tokens := p.tokens
+	expr := PrefixExpression{Span: tokens.Peek(0).Span, Operator: string(tokens.Peek(0).Type)}
 	tokens.Consume(1)
-	expr.Right = parseExpression(tokens, PREFIX)
+	expr.Right = p.parseExpression(PREFIX)
+	expr.Span = spanBetween(expr.Span, expr.Right.Range())
 	return expr
 }
 
-func parseNumberLiteral(tokens *stream.Stream[token.Token]) Expression {
+func (p *Parser) parseNumberLiteral() Expression {
+	tokens := p.tokens
 	num, err := strconv.ParseFloat(tokens.Peek(0).Value, 64)
 	if err != nil {
-		panic(err)
+		panic(errorAt(tokens.Peek(0).Span, "%s", err.Error()))
 	}
-	return NumberLiteral{num}
+	return NumberLiteral{Span: tokens.Peek(0).Span, Value: num}
 }
 
-func parseIdentifier(tokens *stream.Stream[token.Token]) Expression {
-	return Identifier{tokens.Peek(0).Value}
+func (p *Parser) parseIdentifier() Expression {
+	tokens := p.tokens
+	return Identifier{Span: tokens.Peek(0).Span, Value: tokens.Peek(0).Value, Slot: Global}
 }
 
-func parseTextLiteral(tokens *stream.Stream[token.Token]) Expression {
+func (p *Parser) parseTextLiteral() Expression {
+	tokens := p.tokens
+	expr := TextLiteral{Span: tokens.Peek(0).Span}
 	tokens.Consume(1)
-	expr := TextLiteral{}
 	for {
 		if token.IsToken(tokens, token.TEXT_END, 0) {
 			break
 		} else if token.IsToken(tokens, token.TEXT_PART, 0) {
-			expr.Parts = append(expr.Parts, TextPart{tokens.Peek(0).Value})
+			expr.Parts = append(expr.Parts, TextPart{Span: tokens.Peek(0).Span, Value: tokens.Peek(0).Value})
 			tokens.Consume(1)
 		} else {
-			expr.Parts = append(expr.Parts, parseExpression(tokens, LOWEST))
+			expr.Parts = append(expr.Parts, p.parseExpression(LOWEST))
 			tokens.Consume(1)
 		}
 	}
+	expr.Span = spanBetween(expr.Span, tokens.Peek(0).Span)
 	return expr
 }
 
-func parseExpression(tokens *stream.Stream[token.Token], precedence int) Expression {
-	prefix := prefixParsers[tokens.Peek(0).Type]
+func (p *Parser) parseExpression(precedence int) Expression {
+	tokens := p.tokens
+	prefix := p.prefixParsers[tokens.Peek(0).Type]
 	if prefix == nil {
-		panic(fmt.Sprintf("Did not expect %s", tokens.Peek(0).Type))
+		panic(errorAt(tokens.Peek(0).Span, "Did not expect %s", tokens.Peek(0).Type))
 	}
-	leftExpr := prefix(tokens)
+	leftExpr := prefix()
 
 	for !(tokens.Peek(1).Type == token.EOL) && precedence < getPrecedence(*tokens.Peek(1)) {
-		infix := infixParsers[tokens.Peek(1).Type]
+		infix := p.infixParsers[tokens.Peek(1).Type]
 		if infix == nil {
 			return leftExpr
 		}
 		tokens.Consume(1)
-		leftExpr = infix(tokens, leftExpr)
+		leftExpr = infix(leftExpr)
 	}
 
 	return leftExpr
@@ -0,0 +1,95 @@
+package ast
+
+import (
+	"errors"
+	"testing"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+// position is where an error starts and what it says.
+type position struct {
+	line, column int
+	message      string
+}
+
+func parse(t *testing.T, sourceCode string) (Program, []position) {
+	t.Helper()
+	tokens, err := token.Tokenize(sourceCode)
+	if err != nil {
+		t.Fatalf("tokenize: %v", err)
+	}
+	program, err := Parse(&tokens)
+	if err == nil {
+		return program, nil
+	}
+	var list ErrorList
+	if !errors.As(err, &list) {
+		t.Fatalf("expected an ErrorList, got %T", err)
+	}
+	found := []position{}
+	for _, err := range list {
+		found = append(found, position{err.Start.Line, err.Start.Column, err.Message})
+	}
+	return program, found
+}
+
+func expectErrors(t *testing.T, found []position, want ...position) {
+	t.Helper()
+	if len(found) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(found), found)
+	}
+	for i := range want {
+		if found[i] != want[i] {
+			t.Errorf("error %d: expected %v, got %v", i, want[i], found[i])
+		}
+	}
+}
+
+func TestParseReportsEveryError(t *testing.T) {
+	program, found := parse(t, "x: 1 +\ny: 2\nz: * 3\nw: 4\n")
+	expectErrors(t, found,
+		position{1, 7, "Did not expect EOL"},
+		position{3, 4, "Did not expect STAR"},
+	)
+	names := []string{}
+	for _, node := range program.Body {
+		names = append(names, node.(AssignmentStatement).Name.Value)
+	}
+	if len(names) != 2 || names[0] != "y" || names[1] != "w" {
+		t.Fatalf("expected the statements between the errors to be parsed, got %v", names)
+	}
+}
+
+func TestRecoveryStopsAtTheNextStatement(t *testing.T) {
+	t.Run("in a block", func(t *testing.T) {
+		program, found := parse(t, "f(a):\n    x: 1 +\n    case a:\n        1: 2\n        default: 3\ny: )\n")
+		expectErrors(t, found,
+			position{2, 11, "Did not expect EOL"},
+			position{6, 4, "Did not expect RPAREN"},
+		)
+		if len(program.Body) != 1 {
+			t.Fatalf("expected only the function to be parsed, got %d statements", len(program.Body))
+		}
+		body := program.Body[0].(FunctionDeclaration).Body.Body
+		if len(body) != 1 {
+			t.Fatalf("expected the case after the error to be parsed, got %d statements", len(body))
+		}
+		if _, ok := body[0].(CaseExpression); !ok {
+			t.Fatalf("expected a case expression, got %T", body[0])
+		}
+	})
+	t.Run("in a case", func(t *testing.T) {
+		program, found := parse(t, "f(t):\n    case t:\n        1: 2 +\n        2: 3\n        3: * 4\n        default: 0\nf(2)\n")
+		expectErrors(t, found,
+			position{3, 15, "Did not expect EOL"},
+			position{5, 12, "Did not expect STAR"},
+		)
+		if len(program.Body) != 2 {
+			t.Fatalf("expected the function and the call to be parsed, got %d statements", len(program.Body))
+		}
+		caseExpr := program.Body[0].(FunctionDeclaration).Body.Body[0].(CaseExpression)
+		if len(caseExpr.Cases) != 1 || caseExpr.Default == nil {
+			t.Fatalf("expected the arms without errors to be parsed, got %d arms", len(caseExpr.Cases))
+		}
+	})
+}
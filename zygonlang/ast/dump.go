@@ -0,0 +1,77 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+var spanType = reflect.TypeOf(token.Span{})
+
+// Dump returns a readable outline of the syntax tree under node,
+// with every node's type, position and fields.
+func Dump(node Node) string {
+	var out strings.Builder
+	dump(&out, reflect.ValueOf(node), 0)
+	lines := strings.Split(out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func dump(out *strings.Builder, v reflect.Value, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			out.WriteString("nil")
+			return
+		}
+		// ordered maps are written as their entries
+		if keys := v.MethodByName("Keys"); keys.IsValid() {
+			entries := keys.Call(nil)[0]
+			if entries.Len() == 0 {
+				out.WriteString("{}")
+				return
+			}
+			get := v.MethodByName("Get")
+			for i := 0; i < entries.Len(); i++ {
+				out.WriteString("\n" + indent + "- ")
+				dump(out, entries.Index(i), depth+1)
+				out.WriteString("\n" + indent + "  = ")
+				dump(out, get.Call([]reflect.Value{entries.Index(i)})[0], depth+2)
+			}
+			return
+		}
+		dump(out, v.Elem(), depth)
+	case reflect.Struct:
+		out.WriteString(v.Type().Name())
+		if span, ok := v.Interface().(Node); ok {
+			start, end := span.Range().Start, span.Range().End
+			out.WriteString(fmt.Sprintf(" %d:%d-%d:%d", start.Line, start.Column, end.Line, end.Column))
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Type == spanType || !field.IsExported() {
+				continue
+			}
+			out.WriteString("\n" + indent + "  " + field.Name + ": ")
+			dump(out, v.Field(i), depth+2)
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			out.WriteString("[]")
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			out.WriteString("\n" + indent + "- ")
+			dump(out, v.Index(i), depth+1)
+		}
+	case reflect.String:
+		out.WriteString(fmt.Sprintf("%q", v.String()))
+	default:
+		out.WriteString(fmt.Sprint(v.Interface()))
+	}
+}
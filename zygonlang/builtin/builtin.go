@@ -5,21 +5,30 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"thechosenzendro/zygonlang/zygonlang/ast"
 	ordmap "thechosenzendro/zygonlang/zygonlang/orderedmap"
 	"thechosenzendro/zygonlang/zygonlang/types"
 	"thechosenzendro/zygonlang/zygonlang/value"
 
 	"github.com/elliotchance/orderedmap/v2"
-	"github.com/tiendc/go-deepcopy"
 )
 
-func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.Value, value.Value]] {
-	builtinLib := orderedmap.NewOrderedMap[ast.Name, *orderedmap.OrderedMap[value.Value, value.Value]]()
+// Crash is the error Program.crash stops the program with.
+type Crash struct {
+	Reason   string
+	ExitCode int
+}
+
+func (c *Crash) Error() string { return fmt.Sprintf("Crash: %s", c.Reason) }
+
+// Args are the arguments the program was started with, given out by Program.args.
+var Args = []string{}
+
+func BuiltinLib() *orderedmap.OrderedMap[string, value.Table] {
+	builtinLib := orderedmap.NewOrderedMap[string, value.Table]()
 	// IO module
-	ioModule := orderedmap.NewOrderedMap[value.Value, value.Value]()
+	ioModule := value.NewTable()
 	// IO.log
-	ioModule.Set(
+	ioModule = ioModule.Set(
 		value.TableKey{Value: "log"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -29,13 +38,13 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				Rest: nil,
 			},
 
-			Fn: func(args map[string]value.Value) value.Value {
+			Fn: func(args map[string]value.Value) (value.Value, error) {
 				fmt.Print(args["message"].Inspect() + "\n")
-				return nil
+				return nil, nil
 			},
 		})
 	// IO.get
-	ioModule.Set(
+	ioModule = ioModule.Set(
 		value.TableKey{Value: "get"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -44,7 +53,7 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
+			Fn: func(args map[string]value.Value) (value.Value, error) {
 				prompt := args["prompt"].Inspect()
 				fmt.Print(prompt)
 				var input string
@@ -52,15 +61,15 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				if scanner.Scan() {
 					input = scanner.Text()
 				}
-				return value.Text{Value: input}
+				return value.Text{Value: input}, nil
 			},
 		},
 	)
 
 	// Table module
-	tableModule := orderedmap.NewOrderedMap[value.Value, value.Value]()
+	tableModule := value.NewTable()
 	// Table.change
-	tableModule.Set(
+	tableModule = tableModule.Set(
 		value.TableKey{Value: "change"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -70,36 +79,35 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
+			Fn: func(args map[string]value.Value) (value.Value, error) {
 				table := args["table"]
 				switch table := table.(type) {
 				case value.Table:
 				default:
-					panic(fmt.Sprintf("first argument to table.change must be a Table, not a %T", table))
+					return nil, fmt.Errorf("first argument to table.change must be a Table, not a %T", table)
 				}
-				var newTable value.Table
-				deepcopy.Copy(&newTable, table)
+				newTable := table.(value.Table)
 				changes := args["changes"]
 
 				switch changes := changes.(type) {
 				case value.Table:
 				default:
-					panic(fmt.Sprintf("second argument to table.change must be a Table, not a %T", changes))
+					return nil, fmt.Errorf("second argument to table.change must be a Table, not a %T", changes)
 				}
 
 				checkedChanges := changes.(value.Table)
 
 				for _, key := range checkedChanges.Entries.Keys() {
 					value, _ := checkedChanges.Entries.Get(key)
-					newTable.Entries.Set(key, value)
+					newTable = newTable.Set(key, value)
 				}
 
-				return newTable
+				return newTable, nil
 			},
 		},
 	)
 	// Table.delete
-	tableModule.Set(
+	tableModule = tableModule.Set(
 		value.TableKey{Value: "delete"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -109,32 +117,37 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
-				oldTable := args["table"].(value.Table)
-				newTable := value.Table{Entries: orderedmap.NewOrderedMap[value.Value, value.Value]()}
-				ind := 0
-				for _, key := range oldTable.Entries.Keys() {
-					entryValue, _ := oldTable.Entries.Get(key)
-					if key == args["index"] {
-						continue
-					}
-					if key.Type() == types.NUMBER {
-						newTable.Entries.Set(value.Number{Value: float64(ind)}, entryValue)
-						ind += 1
-					} else {
-						newTable.Entries.Set(key, entryValue)
+			Fn: func(args map[string]value.Value) (value.Value, error) {
+				oldTable, ok := args["table"].(value.Table)
+				if !ok {
+					return nil, fmt.Errorf("first argument to Table.delete must be a Table, not a %T", args["table"])
+				}
+				index := args["index"]
+				if _, ok := oldTable.Entries.Get(index); !ok {
+					return oldTable, nil
+				}
+				newTable := value.Table{Entries: oldTable.Entries.Delete(index)}
+				number, ok := index.(value.Number)
+				if !ok {
+					return newTable, nil
+				}
+				// the entries numbered after the deleted one are numbered one lower, keeping their places
+				for i := number.Value + 1; ; i++ {
+					if _, ok := newTable.Entries.Get(value.Number{Value: i}); !ok {
+						break
 					}
+					newTable.Entries = newTable.Entries.Rename(value.Number{Value: i}, value.Number{Value: i - 1})
 				}
 
-				return newTable
+				return newTable, nil
 			},
 		},
 	)
 
 	// Program module
-	programModule := orderedmap.NewOrderedMap[value.Value, value.Value]()
+	programModule := value.NewTable()
 	// Program.crash
-	programModule.Set(
+	programModule = programModule.Set(
 		value.TableKey{Value: "crash"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -144,18 +157,38 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
-				fmt.Printf("Crash: %s\n", args["reason"].Inspect())
-				os.Exit(int(args["exit_code"].(value.Number).Value))
-				return nil
+			Fn: func(args map[string]value.Value) (value.Value, error) {
+				exitCode, ok := args["exit_code"].(value.Number)
+				if !ok {
+					return nil, fmt.Errorf("exit_code of Program.crash must be a Number, not a %T", args["exit_code"])
+				}
+				return nil, &Crash{Reason: args["reason"].Inspect(), ExitCode: int(exitCode.Value)}
+			},
+		},
+	)
+
+	// Program.args
+	programModule = programModule.Set(
+		value.TableKey{Value: "args"},
+		value.BuiltinFunction{
+			Contract: value.BuiltinFunctionContract{
+				Parameters: ordmap.OrderedMapFromArgs([]ordmap.KV[value.TableKey, value.Value]{}),
+				Rest:       nil,
+			},
+			Fn: func(args map[string]value.Value) (value.Value, error) {
+				tbl := value.NewTable()
+				for i, arg := range Args {
+					tbl = tbl.Set(value.Number{Value: float64(i)}, value.Text{Value: arg})
+				}
+				return tbl, nil
 			},
 		},
 	)
 
 	// Error module
-	errorModule := orderedmap.NewOrderedMap[value.Value, value.Value]()
+	errorModule := value.NewTable()
 	// Error.error
-	errorModule.Set(
+	errorModule = errorModule.Set(
 		value.TableKey{Value: "error"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -164,32 +197,34 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
+			Fn: func(args map[string]value.Value) (value.Value, error) {
 				message := args["message"]
 				if message.Type() != types.TEXT {
-					panic("you need to supply text to Errors.error")
+					return nil, fmt.Errorf("you need to supply text to Errors.error")
 				}
-				return value.Error{Value: message.(value.Text).Value}
+				return value.Error{Value: message.(value.Text).Value}, nil
 			},
 		},
 	)
 
 	// Type module
-	typeModule := orderedmap.NewOrderedMap[value.Value, value.Value]()
+	typeModule := value.NewTable()
 	// Type.number
-	typeModule.Set(value.TableKey{Value: "number"}, value.Type{Value: types.NUMBER})
+	typeModule = typeModule.Set(value.TableKey{Value: "number"}, value.Type{Value: types.NUMBER})
 	// Type.boolean
-	typeModule.Set(value.TableKey{Value: "boolean"}, value.Type{Value: types.BOOL})
+	typeModule = typeModule.Set(value.TableKey{Value: "boolean"}, value.Type{Value: types.BOOL})
 	// Type.text
-	typeModule.Set(value.TableKey{Value: "text"}, value.Type{Value: types.TEXT})
+	typeModule = typeModule.Set(value.TableKey{Value: "text"}, value.Type{Value: types.TEXT})
 	// Type.function
-	typeModule.Set(value.TableKey{Value: "function"}, value.Type{Value: types.FUNCTION})
+	typeModule = typeModule.Set(value.TableKey{Value: "function"}, value.Type{Value: types.FUNCTION})
 	// Type.table
-	typeModule.Set(value.TableKey{Value: "table"}, value.Type{Value: types.TABLE})
+	typeModule = typeModule.Set(value.TableKey{Value: "table"}, value.Type{Value: types.TABLE})
 	// Type.error
-	typeModule.Set(value.TableKey{Value: "error"}, value.Type{Value: types.ERROR})
+	typeModule = typeModule.Set(value.TableKey{Value: "error"}, value.Type{Value: types.ERROR})
+	// Type.kind, the type of types like Type.number
+	typeModule = typeModule.Set(value.TableKey{Value: "kind"}, value.Type{Value: types.TYPE})
 	// Type.type
-	typeModule.Set(
+	typeModule = typeModule.Set(
 		value.TableKey{Value: "type"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -198,35 +233,19 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
-				switch args["value"].(type) {
-				case value.Number:
-					return value.Type{Value: types.NUMBER}
-				case value.Boolean:
-					return value.Type{Value: types.BOOL}
-				case value.Text:
-					return value.Type{Value: types.TEXT}
-				case value.Function:
-					return value.Type{Value: types.FUNCTION}
-				case value.BuiltinFunction:
-					return value.Type{Value: types.FUNCTION}
-				case value.Table:
-					return value.Type{Value: types.TABLE}
-				case value.Error:
-					return value.Type{Value: types.ERROR}
-				case value.Type:
-					return value.Type{Value: types.TYPE}
-				default:
-					return value.Error{Value: "unknown type"}
+			Fn: func(args map[string]value.Value) (value.Value, error) {
+				if typ, ok := value.TypeOf(args["value"]); ok {
+					return typ, nil
 				}
+				return value.Error{Value: "unknown type"}, nil
 			},
 		},
 	)
 
 	// Text module
-	textModule := orderedmap.NewOrderedMap[value.Value, value.Value]()
+	textModule := value.NewTable()
 	// Text.split
-	textModule.Set(
+	textModule = textModule.Set(
 		value.TableKey{Value: "split"},
 		value.BuiltinFunction{
 			Contract: value.BuiltinFunctionContract{
@@ -236,24 +255,31 @@ func BuiltinLib() *orderedmap.OrderedMap[ast.Name, *orderedmap.OrderedMap[value.
 				}),
 				Rest: nil,
 			},
-			Fn: func(args map[string]value.Value) value.Value {
-				tbl := value.Table{Entries: orderedmap.NewOrderedMap[value.Value, value.Value]()}
-				split := strings.Split(args["text"].(value.Text).Value, args["separator"].(value.Text).Value)
+			Fn: func(args map[string]value.Value) (value.Value, error) {
+				text, ok := args["text"].(value.Text)
+				if !ok {
+					return nil, fmt.Errorf("first argument to Text.split must be a Text, not a %T", args["text"])
+				}
+				separator, ok := args["separator"].(value.Text)
+				if !ok {
+					return nil, fmt.Errorf("second argument to Text.split must be a Text, not a %T", args["separator"])
+				}
+				tbl := value.NewTable()
+				split := strings.Split(text.Value, separator.Value)
 				for i, s := range split {
-					tbl.Entries.Set(value.Number{Value: float64(i)}, value.Text{Value: s})
+					tbl = tbl.Set(value.Number{Value: float64(i)}, value.Text{Value: s})
 				}
-				fmt.Println("text split", tbl.Inspect())
-				return tbl
+				return tbl, nil
 			},
 		},
 	)
 
-	builtinLib.Set(ast.Identifier{Value: "IO"}, ioModule)
-	builtinLib.Set(ast.Identifier{Value: "Table"}, tableModule)
-	builtinLib.Set(ast.Identifier{Value: "Program"}, programModule)
-	builtinLib.Set(ast.Identifier{Value: "Error"}, errorModule)
-	builtinLib.Set(ast.Identifier{Value: "Type"}, typeModule)
-	builtinLib.Set(ast.Identifier{Value: "Text"}, textModule)
+	builtinLib.Set("IO", ioModule)
+	builtinLib.Set("Table", tableModule)
+	builtinLib.Set("Program", programModule)
+	builtinLib.Set("Error", errorModule)
+	builtinLib.Set("Type", typeModule)
+	builtinLib.Set("Text", textModule)
 
 	return builtinLib
 }
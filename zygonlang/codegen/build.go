@@ -0,0 +1,210 @@
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/analyzer"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/builtin"
+	"thechosenzendro/zygonlang/zygonlang/diagnostic"
+	"thechosenzendro/zygonlang/zygonlang/evaluator"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+// RootEnv is the environment variable that points to the source code of zygon,
+// which compiled programs are built with. Without it, the source code zygon was built from is used.
+const RootEnv = "ZYGON_ROOT"
+
+var builtinLib = builtin.BuiltinLib()
+
+// Error is a problem with a program that stops it from being compiled.
+type Error struct {
+	token.Span
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Start.Line, e.Start.Column, e.Message)
+}
+func (e *Error) Msg() string   { return e.Message }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Build compiles the program in the file at filePath, together with the modules it uses,
+// to an executable at output. The modules are found by loader, the way zygon run finds them.
+// The program is compiled to Go source code, which the go command builds against the
+// source code of zygon, see sourceRoot. The go command has to support the Go version
+// in its go.mod.
+func Build(loader *evaluator.Loader, filePath string, sourceCode string, output string) error {
+	b := &builder{loader: loader, units: map[string]*unit{}}
+	if _, err := b.load(filePath, sourceCode); err != nil {
+		return err
+	}
+	source, err := generate(b.order)
+	if err != nil {
+		return fmt.Errorf("generated invalid Go code: %w", err)
+	}
+
+	root, err := sourceRoot()
+	if err != nil {
+		return err
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "zygon-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	version, err := goVersion(root)
+	if err != nil {
+		return err
+	}
+	goMod := fmt.Sprintf("module zygonprogram\n\ngo %s\n\nrequire thechosenzendro/zygonlang v0.0.0\n\nreplace thechosenzendro/zygonlang => %s\n", version, root)
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{"main.go": source, "go.mod": []byte(goMod), "go.sum": goSum}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), contents, 0644); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command("go", "build", "-mod=mod", "-o", output, ".")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		var notFound *exec.Error
+		if errors.As(err, &notFound) {
+			return fmt.Errorf("building needs the go command: %w", err)
+		}
+		return fmt.Errorf("go build failed: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// sourceRoot returns the directory of the source code of zygon. It is RootEnv when that is set,
+// otherwise the directory zygon was built from, which an installed zygon might not have.
+func sourceRoot() (string, error) {
+	if root := os.Getenv(RootEnv); root != "" {
+		if _, err := os.Stat(filepath.Join(root, "go.mod")); err != nil {
+			return "", fmt.Errorf("%s is set to %s, which is not the source code of zygon", RootEnv, root)
+		}
+		return filepath.Abs(root)
+	}
+	_, file, _, ok := runtime.Caller(0)
+	if ok && filepath.IsAbs(file) {
+		root := filepath.Join(filepath.Dir(file), "..", "..")
+		if _, err := os.Stat(filepath.Join(root, "go.mod")); err == nil {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("the source code of zygon was not found, set %s to its directory", RootEnv)
+}
+
+// goVersion returns the Go version the source code of zygon in root needs.
+func goVersion(root string) (string, error) {
+	goMod, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(goMod), "\n") {
+		if version, ok := strings.CutPrefix(strings.TrimSpace(line), "go "); ok {
+			return strings.TrimSpace(version), nil
+		}
+	}
+	return "", fmt.Errorf("%s does not say which Go version it needs", filepath.Join(root, "go.mod"))
+}
+
+// builder finds the modules of a program, which are compiled into it.
+type builder struct {
+	loader *evaluator.Loader
+	// units are the modules that were loaded, by canonical path
+	units map[string]*unit
+	// order are the modules in the order they were loaded
+	order []*unit
+	// loading is the chain of modules being loaded, each one used by the one before it
+	loading []string
+}
+
+// load parses the module at filePath and loads the modules it uses.
+func (b *builder) load(filePath string, sourceCode string) (*unit, error) {
+	canonical := evaluator.CanonicalPath(filePath)
+	b.loading = append(b.loading, canonical)
+	defer func() { b.loading = b.loading[:len(b.loading)-1] }()
+
+	// the lexer needs to lex indents correctly
+	tokens, err := token.Tokenize(sourceCode + "\n")
+	if err != nil {
+		return nil, err
+	}
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	program, err = analyzer.Analyze(program, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unit{id: len(b.order), path: filePath, source: sourceCode, program: program, uses: map[token.Span]*unit{}}
+	b.order = append(b.order, u)
+	for _, node := range program.Body {
+		using, ok := node.(ast.UsingStatement)
+		if !ok {
+			continue
+		}
+		for _, module := range using.Modules {
+			if _, ok := builtinLib.Get(ast.ModuleName(module.Module)); ok {
+				continue
+			}
+			modulePath, err := b.loader.Find(filePath, module.Module)
+			if err != nil {
+				return nil, &Error{Span: module.Span, Message: err.Error()}
+			}
+			used, err := b.loadModule(modulePath)
+			var cycle *evaluator.ImportCycleError
+			if errors.As(err, &cycle) {
+				return nil, &Error{Span: module.Span, Message: err.Error(), Err: err}
+			} else if err != nil {
+				return nil, err
+			}
+			u.uses[module.Span] = used
+		}
+	}
+	b.units[canonical] = u
+	return u, nil
+}
+
+// loadModule loads a module used by another one, unless it was loaded already.
+// Its errors point into its own source code.
+func (b *builder) loadModule(modulePath string) (*unit, error) {
+	canonical := evaluator.CanonicalPath(modulePath)
+	if u, ok := b.units[canonical]; ok {
+		return u, nil
+	}
+	if cycle := evaluator.ImportCycle(b.loading, canonical); cycle != nil {
+		return nil, cycle
+	}
+
+	source, err := os.ReadFile(modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("no module at %s: %w", modulePath, err)
+	}
+	u, err := b.load(modulePath, string(source))
+	var file *diagnostic.File
+	if err != nil && !errors.As(err, &file) {
+		err = &diagnostic.File{Name: modulePath, Source: string(source), Err: err}
+	}
+	return u, err
+}
@@ -0,0 +1,86 @@
+package codegen
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"thechosenzendro/zygonlang/zygonlang/diagnostic"
+	"thechosenzendro/zygonlang/zygonlang/evaluator"
+)
+
+// evaluate runs the program at filePath on the evaluator and returns what it prints,
+// followed by its error like zygon run shows it.
+func evaluate(t *testing.T, filePath string, sourceCode string) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	printed := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(reader)
+		printed <- out
+	}()
+	_, _, err = evaluator.NewLoader(nil).Exec(filePath, sourceCode)
+	os.Stdout = stdout
+	writer.Close()
+	out := string(<-printed)
+	if err != nil {
+		out += diagnostic.Render(filePath, sourceCode, err)
+	}
+	return out
+}
+
+// TestBuildMatchesEvaluator builds every program in testdata and checks that the executable
+// prints the same as the evaluator, which is kept in the .out file next to the program.
+func TestBuildMatchesEvaluator(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building runs the go command")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("building needs the go command")
+	}
+	programs, err := filepath.Glob(filepath.Join("testdata", "*.zygon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filePath := range programs {
+		t.Run(filepath.Base(filePath), func(t *testing.T) {
+			sourceCode, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden, err := os.ReadFile(strings.TrimSuffix(filePath, ".zygon") + ".out")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := string(golden)
+
+			if got := evaluate(t, filePath, string(sourceCode)); got != want {
+				t.Fatalf("the evaluator printed\n%s\nexpected\n%s", got, want)
+			}
+
+			output := filepath.Join(t.TempDir(), "program")
+			if err := Build(evaluator.NewLoader(nil), filePath, string(sourceCode), output); err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			var out bytes.Buffer
+			cmd := exec.Command(output)
+			cmd.Stdout, cmd.Stderr = &out, &out
+			if err := cmd.Run(); err != nil {
+				if _, exited := err.(*exec.ExitError); !exited {
+					t.Fatalf("run: %v", err)
+				}
+			}
+			if got := out.String(); got != want {
+				t.Fatalf("the executable printed\n%s\nexpected\n%s", got, want)
+			}
+		})
+	}
+}
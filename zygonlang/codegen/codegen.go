@@ -0,0 +1,710 @@
+package codegen
+
+import (
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+// unit is a module of the program being compiled.
+type unit struct {
+	id int
+	// path is the path of the file of the module, as errors show it
+	path    string
+	source  string
+	program ast.Program
+	// uses are the units of the modules used by the program, by the span of the module in its using statement
+	uses map[token.Span]*unit
+}
+
+// scope is the scope of a function call or of a case arm. Its names are kept in Go variables,
+// named after the name and the scope, which the Go functions declared in it close over.
+type scope struct {
+	id    int
+	names map[int]string
+	// inits are the values slots start with, the others start Unset
+	inits map[int]string
+	// bound slots are set before any code of the scope runs, so they need no checks
+	bound map[int]bool
+	read  map[int]bool
+}
+
+// variable returns the Go variable of a slot.
+func (s *scope) variable(slot int) string {
+	return fmt.Sprintf("%s_%d", s.names[slot], s.id)
+}
+
+// generator generates the Go code of a program. Every expression is put in a temporary
+// variable in the order it is evaluated, so the Go code runs in the same order as the zygon code.
+type generator struct {
+	// out is the body of the Go function being generated
+	out *strings.Builder
+	// decls are the Go variables that describe the code, generated next to the functions
+	decls  strings.Builder
+	unit   *unit
+	scopes []*scope
+	// counters of the names of scopes, temporary variables, labels and descriptions
+	numScopes, numTemps, numLabels, numDecls int
+}
+
+// generate generates the Go source code of a program made of units, the first of which is run.
+func generate(units []*unit) ([]byte, error) {
+	g := &generator{}
+	var out strings.Builder
+	out.WriteString("// Code generated by zygon build. DO NOT EDIT.\n\n")
+	out.WriteString("package main\n\n")
+	out.WriteString("import (\n\t\"thechosenzendro/zygonlang/zygonlang/native\"\n\t\"thechosenzendro/zygonlang/zygonlang/value\"\n)\n\n")
+	out.WriteString("func main() {\n\tnative.Main(module0, run0)\n}\n")
+	for _, u := range units {
+		g.unit = u
+		fmt.Fprintf(&out, "\nvar module%d = &native.Module{Name: %q, Source: %q}\n\n", u.id, u.path, u.source)
+		body := g.capture(func() {
+			g.emit("return %s", g.genBody(u.program.Body, true, false))
+		})
+		fmt.Fprintf(&out, "func run%d(env *value.Environment) value.Value {\n%s}\n", u.id, body)
+	}
+	out.WriteString(g.decls.String())
+	return format.Source([]byte(out.String()))
+}
+
+func (g *generator) emit(format string, args ...any) {
+	fmt.Fprintf(g.out, format+"\n", args...)
+}
+
+// capture returns the code generated by generate.
+func (g *generator) capture(generate func()) string {
+	outer := g.out
+	g.out = &strings.Builder{}
+	generate()
+	code := g.out.String()
+	g.out = outer
+	return code
+}
+
+// temp puts the value of expression in a new temporary variable and returns its name.
+func (g *generator) temp(expression string, args ...any) string {
+	g.numTemps += 1
+	name := fmt.Sprintf("t%d", g.numTemps)
+	g.emit("%s := %s", name, fmt.Sprintf(expression, args...))
+	return name
+}
+
+func (g *generator) label(kind string) string {
+	g.numLabels += 1
+	return fmt.Sprintf("%s%d", kind, g.numLabels)
+}
+
+// declare declares a Go variable next to the functions and returns its name.
+func (g *generator) declare(kind string, expression string, args ...any) string {
+	g.numDecls += 1
+	name := fmt.Sprintf("%s%d", kind, g.numDecls)
+	fmt.Fprintf(&g.decls, "\nvar %s = %s\n", name, fmt.Sprintf(expression, args...))
+	return name
+}
+
+// pos returns the Go code of a position in the module being generated.
+func (g *generator) pos(span token.Span) string {
+	return fmt.Sprintf("module%d.At(%d, %d, %d, %d)", g.unit.id, span.Start.Line, span.Start.Column, span.End.Line, span.End.Column)
+}
+
+func (g *generator) fail(span token.Span, format string, args ...any) string {
+	g.emit("native.Fail(%s, %q)", g.pos(span), fmt.Sprintf(format, args...))
+	return "nil"
+}
+
+func (g *generator) pushScope() *scope {
+	s := g.newScope()
+	g.scopes = append(g.scopes, s)
+	return s
+}
+
+// newScope returns a scope without entering it, for code outside of it to set its names first.
+func (g *generator) newScope() *scope {
+	g.numScopes += 1
+	return &scope{id: g.numScopes, names: map[int]string{0: "_"}, inits: map[int]string{}, bound: map[int]bool{}, read: map[int]bool{}}
+}
+
+func (g *generator) popScope() {
+	g.scopes = g.scopes[:len(g.scopes)-1]
+}
+
+// declarations returns the declarations of the variables of a scope, to go before its code.
+func (g *generator) declarations(s *scope) string {
+	slots := []int{}
+	for slot := range s.names {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	var out strings.Builder
+	for _, slot := range slots {
+		init, ok := s.inits[slot]
+		if !ok {
+			init = "native.Unset"
+		}
+		fmt.Fprintf(&out, "%s := %s\n", s.variable(slot), init)
+		if !s.read[slot] {
+			fmt.Fprintf(&out, "_ = %s\n", s.variable(slot))
+		}
+	}
+	return out.String()
+}
+
+// local returns the scope of a name that is not global.
+func (g *generator) local(ident ast.Identifier) *scope {
+	s := g.scopes[len(g.scopes)-1-ident.Depth]
+	s.names[ident.Slot] = ident.Value
+	return s
+}
+
+func (g *generator) define(ident ast.Identifier, val string) {
+	if ident.Slot == ast.Global {
+		g.emit("env.Set(%q, %s)", ident.Value, val)
+		return
+	}
+	g.emit("%s = %s", g.local(ident).variable(ident.Slot), val)
+}
+
+// setLast sets _ of the current scope to the value of an expression statement.
+func (g *generator) setLast(val string, tail bool) {
+	if len(g.scopes) == 0 {
+		g.emit("env.Set(\"_\", %s)", val)
+		return
+	}
+	last := g.scopes[len(g.scopes)-1].variable(0)
+	if tail {
+		g.emit("%s = native.Last(%s, %s)", last, last, val)
+	} else {
+		g.emit("%s = %s", last, val)
+	}
+}
+
+// genBody generates the statements of a program or a block and returns the value of the
+// last one. With tail set, the block is in tail position of a function.
+func (g *generator) genBody(body []ast.Node, topLevel bool, tail bool) string {
+	result := "nil"
+	for i, node := range body {
+		switch node := node.(type) {
+		case ast.AssignmentStatement, ast.FunctionDeclaration:
+			result = g.gen(node)
+		case ast.UsingStatement:
+			if !topLevel {
+				result = g.fail(node.Span, "a using statement can only be at the top level")
+				continue
+			}
+			result = g.gen(node)
+		case ast.PubStatement:
+			if !topLevel {
+				result = g.fail(node.Span, "a pub statement can only be at the top level")
+				continue
+			}
+			result = g.gen(node)
+		default:
+			if tail && i == len(body)-1 {
+				result = g.genTail(node)
+				g.setLast(result, true)
+			} else {
+				result = g.gen(node)
+				g.setLast(result, false)
+			}
+		}
+	}
+	return result
+}
+
+// gen generates node and returns Go code of its value, which is either a constant
+// or a variable that is not set again.
+func (g *generator) gen(node ast.Node) string {
+	switch node := node.(type) {
+	case ast.NumberLiteral:
+		return fmt.Sprintf("value.Number{Value: %s}", strconv.FormatFloat(node.Value, 'g', -1, 64))
+	case ast.BooleanLiteral:
+		return fmt.Sprintf("value.Boolean{Value: %t}", node.Value)
+	case ast.TextLiteral:
+		parts := []string{}
+		for _, part := range node.Parts {
+			switch part := part.(type) {
+			case ast.TextPart:
+				parts = append(parts, fmt.Sprintf("value.Text{Value: %q}", part.Value))
+			default:
+				parts = append(parts, g.gen(part))
+			}
+		}
+		return g.temp("native.Text(%s)", strings.Join(parts, ", "))
+	case ast.PrefixExpression:
+		right := g.gen(node.Right)
+		switch node.Operator {
+		case token.NOT:
+			return g.temp("native.Not(%s, %s)", right, g.pos(node.Right.Range()))
+		case token.MINUS:
+			return g.temp("native.Negate(%s, %s)", right, g.pos(node.Right.Range()))
+		}
+		return "nil"
+	case ast.InfixExpression:
+		return g.genInfix(node)
+	case ast.Block:
+		return g.genBody(node.Body, false, false)
+	case ast.CaseExpression:
+		return g.genCase(node, false)
+	case ast.ChainExpression:
+		return g.genChain(node)
+	case ast.AssignmentStatement:
+		val := g.genBody(node.Value.Body, false, false)
+		g.define(node.Name, fmt.Sprintf("native.Defined(%s, %s)", val, g.pos(node.Value.Span)))
+		return "nil"
+	case ast.AccessOperator:
+		subject := g.gen(node.Subject)
+		index := "nil"
+		switch attribute := node.Attribute.(type) {
+		case ast.Identifier:
+			index = fmt.Sprintf("value.TableKey{Value: %q}", attribute.Value)
+		case ast.Grouped:
+			index = g.gen(attribute.Value)
+		}
+		return g.temp("native.Index(%s, %s, %q, %s, %s)", subject, index, ast.SubjectName(node.Subject), g.pos(node.Subject.Range()), g.pos(node.Attribute.Range()))
+	case ast.Identifier:
+		if node.Slot == ast.Global {
+			return g.temp("native.Global(env, %q, %s)", node.Value, g.pos(node.Span))
+		}
+		s := g.local(node)
+		s.read[node.Slot] = true
+		if s.bound[node.Slot] {
+			return s.variable(node.Slot)
+		}
+		return g.temp("native.Local(%s, %q, %s)", s.variable(node.Slot), node.Value, g.pos(node.Span))
+	case ast.FunctionDeclaration:
+		return g.genFunction(node)
+	case ast.FunctionCall:
+		return g.genCall(node, false)
+	case ast.TableLiteral:
+		entries := []string{}
+		values := []string{}
+		for _, entry := range node.Entries {
+			if entry.Computed != nil {
+				entries = append(entries, fmt.Sprintf("{Computed: true, Pos: %s}", g.pos(entry.Computed.Span)))
+				values = append(values, g.gen(entry.Computed.Value), g.gen(entry.Value))
+			} else if entry.Key != nil {
+				entries = append(entries, fmt.Sprintf("{Key: %q}", entry.Key.Value))
+				values = append(values, g.gen(entry.Value))
+			} else if rest, ok := entry.Value.(ast.RestOperator); ok {
+				entries = append(entries, fmt.Sprintf("{Spread: true, Pos: %s}", g.pos(rest.Span)))
+				values = append(values, g.gen(rest.Value))
+			} else {
+				entries = append(entries, "{}")
+				values = append(values, g.gen(entry.Value))
+			}
+		}
+		desc := g.declare("table", "[]native.TableEntry{%s}", strings.Join(entries, ", "))
+		return g.temp("native.Table(%s)", strings.Join(append([]string{desc}, values...), ", "))
+	case ast.PubStatement:
+		switch pub := node.Public.(type) {
+		case ast.AssignmentStatement:
+			g.gen(pub)
+			g.emit("native.Publish(env, %q)", pub.Name.Value)
+		case ast.FunctionDeclaration:
+			if pub.Name == nil {
+				return g.fail(node.Span, "anonymous function could not be made public")
+			}
+			g.gen(pub)
+			g.emit("native.Publish(env, %q)", pub.Name.Value)
+		default:
+			return g.fail(node.Span, "%T cannot be made public", pub)
+		}
+		return "nil"
+	case ast.UsingStatement:
+		for _, module := range node.Modules {
+			args := []string{"env"}
+			if used, ok := g.unit.uses[module.Span]; ok {
+				args = append(args, fmt.Sprintf("module%d", used.id), fmt.Sprintf("run%d", used.id))
+			}
+			args = append(args, strconv.Quote(moduleName(module.Module)))
+			for _, symbol := range module.Symbols {
+				args = append(args, strconv.Quote(symbol.Value))
+			}
+			if len(args) == 2 {
+				g.emit("native.UseBuiltin(%s)", strings.Join(args, ", "))
+			} else {
+				g.emit("native.UseModule(%s)", strings.Join(args, ", "))
+			}
+		}
+		return "nil"
+	case ast.RestOperator:
+		return g.fail(node.Span, "rest operator is not a normal expression and cant be used on its own. ")
+	}
+	return g.fail(node.Range(), "eval error %T", node)
+}
+
+// genTail generates node in tail position of a function, where a call of a
+// function takes the place of the function it is in.
+func (g *generator) genTail(node ast.Node) string {
+	switch node := node.(type) {
+	case ast.Block:
+		return g.genBody(node.Body, false, true)
+	case ast.CaseExpression:
+		return g.genCase(node, true)
+	case ast.FunctionCall:
+		return g.genCall(node, true)
+	}
+	return g.gen(node)
+}
+
+func (g *generator) genInfix(node ast.InfixExpression) string {
+	switch node.Operator {
+	case token.AND:
+		left := g.gen(node.Left)
+		g.emit("native.CheckBool(%s, %s, %q)", left, g.pos(node.Left.Range()), "left arg in and does not eval to a boolean")
+		right := g.gen(node.Right)
+		g.emit("native.CheckBool(%s, %s, %q)", right, g.pos(node.Right.Range()), "right arg in and does not eval to a boolean")
+		return g.temp("native.And(%s, %s)", left, right)
+	case token.OR:
+		left := g.gen(node.Left)
+		g.emit("native.CheckBool(%s, %s, %q)", left, g.pos(node.Left.Range()), "left arg in or does not eval to a boolean")
+		result := g.temp("%s", left)
+		g.emit("if !native.IsTrue(%s) {", left)
+		right := g.gen(node.Right)
+		g.emit("native.CheckBool(%s, %s, %q)", right, g.pos(node.Right.Range()), "right arg in or does not eval to a boolean")
+		g.emit("%s = %s", result, right)
+		g.emit("}")
+		return result
+	}
+	left := g.gen(node.Left)
+	right := g.gen(node.Right)
+	switch node.Operator {
+	case token.IS:
+		return g.temp("native.Equal(%s, %s)", left, right)
+	case token.IS_NOT:
+		return g.temp("native.NotEqual(%s, %s)", left, right)
+	}
+	operations := map[string]string{
+		token.PLUS:         "Add",
+		token.MINUS:        "Sub",
+		token.STAR:         "Mul",
+		token.SLASH:        "Div",
+		token.GREATER_THAN: "Greater",
+		token.LESSER_THAN:  "Less",
+	}
+	operation, ok := operations[node.Operator]
+	if !ok {
+		return "nil"
+	}
+	return g.temp("native.%s(%s, %s, %s)", operation, left, right, g.pos(node.OperatorSpan))
+}
+
+func (g *generator) genFunction(node ast.FunctionDeclaration) string {
+	params := []string{}
+	defaults := []string{}
+	hasDefaults := false
+	for _, param := range node.Parameters.Keys() {
+		params = append(params, strconv.Quote(param))
+		// defaults are evaluated where the function is declared
+		paramDefault, _ := node.Parameters.Get(param)
+		if paramDefault == nil {
+			defaults = append(defaults, "nil")
+			continue
+		}
+		defaults = append(defaults, g.gen(paramDefault))
+		hasDefaults = true
+	}
+
+	s := g.pushScope()
+	for i, param := range node.Parameters.Keys() {
+		s.names[i+1] = param
+		s.inits[i+1] = fmt.Sprintf("args[%d]", i)
+	}
+	if node.Rest != nil {
+		if rest, ok := node.Rest.Value.(ast.Identifier); ok {
+			s.names[rest.Slot] = rest.Value
+			s.inits[rest.Slot] = fmt.Sprintf("args[%d]", len(params))
+		}
+	}
+	body := g.capture(func() {
+		g.emit("return %s", g.genBody(node.Body.Body, false, true))
+	})
+	g.popScope()
+
+	fields := []string{}
+	if node.Name != nil {
+		fields = append(fields, fmt.Sprintf("Name: %q", node.Name.Value))
+	}
+	if len(params) > 0 {
+		fields = append(fields, fmt.Sprintf("Params: []string{%s}", strings.Join(params, ", ")))
+	}
+	if hasDefaults {
+		fields = append(fields, fmt.Sprintf("Defaults: []value.Value{%s}", strings.Join(defaults, ", ")))
+	}
+	if node.Rest != nil {
+		fields = append(fields, "Rest: true")
+	}
+	fields = append(fields, fmt.Sprintf("Body: func(args []value.Value) value.Value {\n%s%s}", g.declarations(s), body))
+	fn := g.temp("&native.Function{%s}", strings.Join(fields, ", "))
+	if node.Name != nil {
+		g.define(*node.Name, fn)
+	}
+	return fn
+}
+
+func (g *generator) genCall(node ast.FunctionCall, tail bool) string {
+	fn := g.gen(node.Fn)
+	arguments := []string{}
+	values := []string{}
+	for _, arg := range node.Arguments {
+		switch argValue := arg.Value.(type) {
+		case ast.RestOperator:
+			arguments = append(arguments, fmt.Sprintf("{Spread: true, Pos: %s}", g.pos(argValue.Span)))
+			values = append(values, g.gen(argValue.Value))
+		default:
+			if arg.Name != nil {
+				arguments = append(arguments, fmt.Sprintf("{Name: %q}", arg.Name.Value))
+			} else {
+				arguments = append(arguments, "{}")
+			}
+			values = append(values, g.gen(argValue))
+		}
+	}
+	site := g.declare("site", "&native.CallSite{Pos: %s, Args: []native.Argument{%s}}", g.pos(node.Span), strings.Join(arguments, ", "))
+	call := "Call"
+	if tail {
+		call = "TailCall"
+	}
+	return g.temp("native.%s(%s)", call, strings.Join(append([]string{site, fn}, values...), ", "))
+}
+
+// genCase generates a case expression. Every arm is a Go block that jumps to the end
+// of the case when its pattern matches, and runs in a scope of its own.
+func (g *generator) genCase(node ast.CaseExpression, tail bool) string {
+	g.numTemps += 1
+	result := fmt.Sprintf("t%d", g.numTemps)
+	g.emit("var %s value.Value", result)
+	end := g.label("end")
+
+	g.emit("{")
+	subject := ""
+	if node.Subject != nil {
+		subject = g.gen(node.Subject)
+		if len(node.Cases) == 0 {
+			g.emit("_ = %s", subject)
+		}
+	}
+	for _, arm := range node.Cases {
+		g.emit("{")
+		s := g.newScope()
+		next := g.label("next")
+
+		match := g.capture(func() {
+			if subject == "" {
+				g.matchConditions(arm.Pattern, next)
+			} else {
+				values, binds := g.matchPattern(arm.Pattern, subject)
+				g.emit("if !ok {")
+				g.emit("goto %s", next)
+				g.emit("}")
+				for i, bind := range binds {
+					s.names[bind.Slot] = bind.Value
+					s.bound[bind.Slot] = true
+					g.emit("%s = %s[%d]", s.variable(bind.Slot), values, i)
+				}
+			}
+			if arm.Guard != nil {
+				g.scopes = append(g.scopes, s)
+				val := g.gen(arm.Guard)
+				g.popScope()
+				g.emit("if !native.CheckGuard(%s, %s) {", val, g.pos(arm.Guard.Range()))
+				g.emit("goto %s", next)
+				g.emit("}")
+			}
+		})
+
+		g.scopes = append(g.scopes, s)
+		body := g.capture(func() {
+			val := ""
+			if tail {
+				val = g.genTail(arm.Block)
+			} else {
+				val = g.gen(arm.Block)
+			}
+			g.emit("%s = %s", result, val)
+			g.emit("goto %s", end)
+		})
+		g.popScope()
+		g.out.WriteString(g.declarations(s))
+		g.out.WriteString(match)
+		g.out.WriteString(body)
+		g.emit("}")
+		g.emit("%s:", next)
+	}
+
+	if node.Default != nil {
+		val := ""
+		if tail {
+			val = g.genTail(*node.Default)
+		} else {
+			val = g.gen(*node.Default)
+		}
+		g.emit("%s = %s", result, val)
+	} else {
+		g.fail(node.Span, "No truthy case in case expr")
+	}
+	g.emit("}")
+	if len(node.Cases) > 0 {
+		g.emit("%s:", end)
+	}
+	return result
+}
+
+// matchConditions generates the conditions of an arm of a case without a subject, which
+// go to next when none of them is true.
+func (g *generator) matchConditions(pattern ast.Pattern, next string) {
+	conditions := []ast.Pattern{pattern}
+	if alternatives, ok := pattern.(ast.AlternativePattern); ok {
+		conditions = alternatives.Alternatives
+	}
+	g.numTemps += 1
+	matched := fmt.Sprintf("t%d", g.numTemps)
+	g.emit("%s := false", matched)
+	for _, condition := range conditions {
+		literal := condition.(ast.LiteralPattern)
+		// a condition is only evaluated when the ones before it are false
+		g.emit("if !%s {", matched)
+		val := g.gen(literal.Value)
+		g.emit("%s = native.CheckPattern(%s, %s)", matched, val, g.pos(literal.Span))
+		g.emit("}")
+	}
+	g.emit("if !%s {", matched)
+	g.emit("goto %s", next)
+	g.emit("}")
+}
+
+// matchPattern generates the matching of subject to a pattern, which sets ok. It returns
+// the variable of the values of the names the pattern binds, and the names.
+func (g *generator) matchPattern(pattern ast.Pattern, subject string) (string, []ast.Identifier) {
+	literals := []string{}
+	binds := []ast.Identifier{}
+	desc := g.declare("pattern", "%s", g.pattern(pattern, &literals, &binds))
+	values := "_"
+	if len(binds) > 0 {
+		g.numTemps += 1
+		values = fmt.Sprintf("t%d", g.numTemps)
+	}
+	g.emit("%s, ok := native.Match(%s)", values, strings.Join(append([]string{desc, strconv.Itoa(len(binds)), subject}, literals...), ", "))
+	return values, binds
+}
+
+// pattern returns the Go code of a pattern. The literals in it are added to literals, and
+// the names it binds to binds, once even when every alternative binds them.
+func (g *generator) pattern(pattern ast.Pattern, literals *[]string, binds *[]ast.Identifier) string {
+	switch pattern := pattern.(type) {
+	case ast.BindingPattern:
+		return fmt.Sprintf("&native.Pattern{Kind: native.BindPattern, Bind: %d}", bindIndex(binds, pattern.Name))
+	case ast.WildcardPattern:
+		return "&native.Pattern{Kind: native.WildcardPattern}"
+	case ast.AlternativePattern:
+		alternatives := []string{}
+		for _, alternative := range pattern.Alternatives {
+			alternatives = append(alternatives, g.pattern(alternative, literals, binds))
+		}
+		return fmt.Sprintf("&native.Pattern{Kind: native.AlternativePattern, Alternatives: []*native.Pattern{%s}}", strings.Join(alternatives, ", "))
+	case ast.TablePattern:
+		entries := []string{}
+		positional := 0
+		for _, entry := range pattern.Entries {
+			key := fmt.Sprintf("value.Number{Value: %d}", positional)
+			if entry.Key != nil {
+				key = fmt.Sprintf("value.TableKey{Value: %q}", entry.Key.Value)
+			} else {
+				positional += 1
+			}
+			entries = append(entries, fmt.Sprintf("{Key: %s, Value: %s}", key, g.pattern(entry.Value, literals, binds)))
+		}
+		rest := ""
+		if pattern.Rest != nil {
+			bind := -1
+			if pattern.Rest.Name != nil {
+				bind = bindIndex(binds, *pattern.Rest.Name)
+			}
+			rest = fmt.Sprintf(", Rest: true, Bind: %d", bind)
+		}
+		return fmt.Sprintf("&native.Pattern{Kind: native.TablePattern, Entries: []native.PatternEntry{%s}%s}", strings.Join(entries, ", "), rest)
+	case ast.TypePattern:
+		bind := -1
+		if pattern.Name != nil {
+			bind = bindIndex(binds, *pattern.Name)
+		}
+		literal := g.literal(pattern.Type, literals)
+		return fmt.Sprintf("&native.Pattern{Kind: native.TypePattern, Literal: %d, Bind: %d, Pos: %s}", literal, bind, g.pos(pattern.Type.Range()))
+	default:
+		literal := g.literal(pattern.(ast.LiteralPattern).Value, literals)
+		return fmt.Sprintf("&native.Pattern{Kind: native.LiteralPattern, Literal: %d}", literal)
+	}
+}
+
+// literal adds a value of a pattern to literals and returns its index. Literals are evaluated
+// outside of the arm, when they are matched.
+func (g *generator) literal(expression ast.Expression, literals *[]string) int {
+	literal := g.capture(func() {
+		g.emit("return %s", g.gen(expression))
+	})
+	*literals = append(*literals, fmt.Sprintf("func() value.Value {\n%s}", literal))
+	return len(*literals) - 1
+}
+
+// bindIndex returns the index of a name a pattern binds, adding it to binds the first time.
+func bindIndex(binds *[]ast.Identifier, name ast.Identifier) int {
+	for i, bind := range *binds {
+		if bind.Slot == name.Slot {
+			return i
+		}
+	}
+	*binds = append(*binds, name)
+	return len(*binds) - 1
+}
+
+// genChain generates a chain expression. Its lines run in a scope of their own, and a line
+// whose value does not match its pattern jumps to the end of the chain.
+func (g *generator) genChain(node ast.ChainExpression) string {
+	g.numTemps += 1
+	result := fmt.Sprintf("t%d", g.numTemps)
+	g.emit("var %s value.Value", result)
+	end := g.label("end")
+
+	s := g.pushScope()
+	body := g.capture(func() {
+		for _, line := range node.Lines {
+			g.emit("{")
+			val := g.gen(line.Value)
+			g.emit("%s = %s", result, val)
+			values, binds := g.matchPattern(line.Pattern, val)
+			g.emit("if !ok {")
+			g.emit("goto %s", end)
+			g.emit("}")
+			for i, bind := range binds {
+				s.names[bind.Slot] = bind.Value
+				g.emit("%s = %s[%d]", s.variable(bind.Slot), values, i)
+			}
+			g.setLast(val, false)
+			g.emit("}")
+		}
+	})
+	g.popScope()
+	g.emit("{")
+	g.out.WriteString(g.declarations(s))
+	g.out.WriteString(body)
+	g.emit("}")
+	if len(node.Lines) > 0 {
+		g.emit("%s:", end)
+	}
+	return result
+}
+
+// moduleName returns the name a module is put in the scope under, the last one of its path.
+func moduleName(module ast.Name) string {
+	switch module := module.(type) {
+	case ast.AccessOperator:
+		return moduleName(module.Attribute.(ast.Name))
+	case ast.Identifier:
+		return module.Value
+	}
+	return ""
+}
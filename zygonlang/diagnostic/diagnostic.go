@@ -0,0 +1,108 @@
+package diagnostic
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+// Positioned is an error that knows which part of the source code caused it.
+type Positioned interface {
+	error
+	Range() token.Span
+	// Msg returns the message without the position.
+	Msg() string
+}
+
+// File marks an error as coming from a module other than the one being run,
+// so it can be shown with the right source code.
+type File struct {
+	Name   string
+	Source string
+	Err    error
+}
+
+func (f *File) Error() string { return fmt.Sprintf("%s:%s", f.Name, f.Err.Error()) }
+func (f *File) Unwrap() error { return f.Err }
+
+// Render formats an error for the user. Errors made of multiple errors are
+// rendered one after another. Positioned errors show the line
+// they happened on with the offending code underlined:
+//
+//	   _____Main.zygon_____
+//	1 │ IO.log(name)
+//	           ^^^^ "name" is not defined
+func Render(name string, source string, err error) string {
+	var file *File
+	if errors.As(err, &file) {
+		name, source, err = file.Name, file.Source, file.Err
+	}
+	if list, ok := err.(interface{ Unwrap() []error }); ok {
+		rendered := []string{}
+		for _, err := range list.Unwrap() {
+			rendered = append(rendered, Render(name, source, err))
+		}
+		return strings.Join(rendered, "\n")
+	}
+	header := fmt.Sprintf("_____%s_____", filepath.Base(name))
+
+	var positioned Positioned
+	if !errors.As(err, &positioned) {
+		return fmt.Sprintf("   %s\n%s\n", header, err.Error())
+	}
+	span := positioned.Range()
+	lines := strings.Split(source, "\n")
+	// the source is lexed with a newline after it, so errors at its end can be past its last line
+	if span.Start.Line > len(lines) || (span.Start.Line == len(lines) && lines[len(lines)-1] == "") {
+		last := len(lines)
+		for last > 1 && strings.TrimSpace(lines[last-1]) == "" {
+			last -= 1
+		}
+		end := token.Position{Line: last, Column: len([]rune(strings.TrimRight(lines[last-1], "\r"))) + 1}
+		span = token.Span{Start: end, End: end}
+	}
+	if span.Start.Line < 1 {
+		return fmt.Sprintf("   %s\n%s\n", header, positioned.Msg())
+	}
+	line := []rune(strings.TrimRight(lines[span.Start.Line-1], "\r"))
+	gutter := strconv.Itoa(span.Start.Line)
+
+	// tabs are expanded so that the underline lines up with the code above it
+	var code strings.Builder
+	underlineStart, underlineEnd := -1, -1
+	width := 0
+	for i, r := range line {
+		column := i + 1
+		if column == span.Start.Column {
+			underlineStart = width
+		}
+		if span.End.Line == span.Start.Line && column == span.End.Column {
+			underlineEnd = width
+		}
+		if r == '\t' {
+			code.WriteString("    ")
+			width += 4
+		} else {
+			code.WriteRune(r)
+			width += 1
+		}
+	}
+	if underlineStart == -1 {
+		underlineStart = width
+	}
+	if underlineEnd == -1 {
+		underlineEnd = width
+	}
+	if underlineEnd <= underlineStart {
+		underlineEnd = underlineStart + 1
+	}
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("%s%s\n", strings.Repeat(" ", len(gutter)+2), header))
+	out.WriteString(fmt.Sprintf("%s │ %s\n", gutter, code.String()))
+	out.WriteString(fmt.Sprintf("%s%s %s\n", strings.Repeat(" ", len(gutter)+3+underlineStart), strings.Repeat("^", underlineEnd-underlineStart), positioned.Msg()))
+	return out.String()
+}
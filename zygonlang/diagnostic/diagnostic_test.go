@@ -0,0 +1,27 @@
+package diagnostic
+
+import (
+	"strings"
+	"testing"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+func TestRenderErrorAtEOF(t *testing.T) {
+	for _, sourceCode := range []string{"x: case 1:", "x: case 1:\n", "x: case 1:\n\n"} {
+		// the source is lexed with a newline after it, like the CLI does
+		tokens, err := token.Tokenize(sourceCode + "\n")
+		if err != nil {
+			t.Fatalf("tokenize: %v", err)
+		}
+		_, err = ast.Parse(&tokens)
+		if err == nil {
+			t.Fatalf("%q: expected a syntax error", sourceCode)
+		}
+		rendered := Render("Main.zygon", sourceCode, err)
+		want := "1 │ x: case 1:\n" + strings.Repeat(" ", 14) + "^ case expression must contain indentation\n"
+		if !strings.HasSuffix(rendered, want) {
+			t.Fatalf("%q: expected the caret after the last line, got\n%s", sourceCode, rendered)
+		}
+	}
+}
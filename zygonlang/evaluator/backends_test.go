@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"errors"
+	"testing"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+// runOn runs sourceCode on the virtual machine when useVM is set, otherwise on the evaluator.
+func runOn(t *testing.T, sourceCode string, useVM bool) (value.Value, error) {
+	t.Helper()
+	loader := NewLoader(nil)
+	loader.UseVM = useVM
+	result, _, err := loader.Exec("", sourceCode)
+	return result, err
+}
+
+func TestBackendsAgree(t *testing.T) {
+	tests := []struct {
+		name       string
+		sourceCode string
+		// want is the source code of the value the program returns
+		want string
+	}{
+		{"case with guards", `describe(point):
+    case point:
+        {x: x, y: y} if x is y: "on the diagonal"
+        {x: x, y: y}: "somewhere else"
+        default: "not a point"
+a: describe({x: 2, y: 2})
+b: describe({x: 1, y: 2})
+c: describe(3)
+{a, b, c}
+`, `{"on the diagonal", "somewhere else", "not a point"}`},
+		{"case with alternatives", `second(t):
+    case t:
+        {1, x} | {x}: x
+        default: "none"
+a: second({1, 5})
+b: second({9})
+c: second({})
+{a, b, c}
+`, `{5, 9, "none"}`},
+		{"nested patterns", `role(account):
+    case account:
+        {user: {name: n, roles: {"admin", ...}}, id: _}: "{n} is an admin"
+        {user: {name: n, ...others}, ...}: others
+        default: "nobody"
+a: role({user: {name: "ann", roles: {"admin", "dev"}}, id: 1})
+b: role({user: {name: "bob", age: 3}})
+c: role(1)
+{a, b, c}
+`, `{"ann is an admin", {age: 3}, "nobody"}`},
+		{"chains", `using Error
+find(id):
+    case id:
+        1: {name: "ann", admin: true}
+        2: {name: "bob", admin: false}
+        default: Error.error("no user {id}")
+is_admin(user):
+    case user.admin:
+        true: true
+        default: Error.error("not an admin")
+login(id):
+    chain:
+        find(id): {name: name, ...}
+        is_admin(_): true
+        "welcome {name}": "welcome {name}"
+a: login(1)
+b: login(2)
+c: login(3)
+{a, b, c}
+`, `using Error
+b: Error.error("not an admin")
+c: Error.error("no user 3")
+{"welcome ann", b, c}
+`},
+		{"spread", `t: {a: 1}
+f(a, b):
+    a - b
+a: {...t, b: 2}
+b: f(2, ...{b: 1})
+{a, b}
+`, `{{a: 1, b: 2}, 1}`},
+		{"named arguments and defaults", `f(a, b: 10):
+    a - b
+g(a, ...rest):
+    rest
+a: f(1)
+b: f(b: 1, a: 5)
+c: f(3, 1)
+d: g(1, 2, x: 3)
+{a, b, c, d}
+`, `{-9, 4, 2, {2, x: 3}}`},
+		{"tail calls", `sum(n, total):
+    case n:
+        0: total
+        default: sum(n - 1, total + n)
+sum(100000, 0)
+`, `5000050000`},
+		{"closures", `adder(n):
+    add(m):
+        n + m
+    add
+add2: adder(2)
+add2(3)
+`, `5`},
+	}
+	for _, test := range tests {
+		want, _, err := Exec(test.want)
+		if err != nil {
+			t.Fatalf("%s: want: %v", test.name, err)
+		}
+		for _, useVM := range []bool{false, true} {
+			got, err := runOn(t, test.sourceCode, useVM)
+			if err != nil {
+				t.Errorf("%s (vm: %t): unexpected error: %v", test.name, useVM, err)
+				continue
+			}
+			if !value.Equal(got, want) {
+				t.Errorf("%s (vm: %t): expected %s, got %s", test.name, useVM, want.Inspect(), got.Inspect())
+			}
+		}
+	}
+}
+
+func TestBackendsReportTheSameErrors(t *testing.T) {
+	// pick hides the type of what it returns from the analyzer, so the errors happen at runtime
+	pick := `pick(n):
+    case n:
+        0: "zero"
+        default: {}
+`
+	tests := []struct {
+		name       string
+		sourceCode string
+		message    string
+		span       token.Span
+	}{
+		{"arithmetic on a text", pick + "pick(0) + 1\n", "expected two numbers, got Text and Number", span(5, 9, 5, 10)},
+		{"negating a text", pick + "x: pick(0)\n-x\n", "non number passed to -", span(6, 2, 6, 3)},
+		{"indexing a text", pick + "pick(0).b\n", "Cannot index type value.Text", span(5, 1, 5, 8)},
+		{"error in a tail call", pick + "count(n):\n    case n:\n        0: pick(0) * 2\n        default: count(n - 1)\ncount(10)\n", "expected two numbers, got Text and Number", span(7, 20, 7, 21)},
+		{"missing argument", "pick(n):\n    case n:\n        0: pick\n        default: {}\nf: pick(0)\nf()\n", "no default for n", span(6, 1, 6, 4)},
+		{"spreading without names", "f(a):\n    a\nf(...{1})\n", "Cannot spread items without names", span(3, 3, 3, 9)},
+	}
+	for _, test := range tests {
+		for _, useVM := range []bool{false, true} {
+			_, err := runOn(t, test.sourceCode, useVM)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Errorf("%s (vm: %t): expected a runtime error, got %v", test.name, useVM, err)
+				continue
+			}
+			got := span(runtimeErr.Start.Line, runtimeErr.Start.Column, runtimeErr.End.Line, runtimeErr.End.Column)
+			if runtimeErr.Message != test.message || got != test.span {
+				t.Errorf("%s (vm: %t): expected %q at %v, got %q at %v", test.name, useVM, test.message, test.span, runtimeErr.Message, got)
+			}
+		}
+	}
+}
+
+// span makes a span without offsets, which the tests do not check.
+func span(startLine, startColumn, endLine, endColumn int) token.Span {
+	return token.Span{Start: token.Position{Line: startLine, Column: startColumn}, End: token.Position{Line: endLine, Column: endColumn}}
+}
@@ -0,0 +1,128 @@
+package evaluator
+
+import (
+	"encoding/binary"
+)
+
+// opcode is the first byte of an instruction. Its operands follow it, operandWidth bytes each.
+type opcode byte
+
+// operandWidth is the size of an operand, wide enough for any constant index or jump
+// target of a chunk.
+const operandWidth = 4
+
+const (
+	opConstant opcode = iota
+	opNil
+	opPop
+	opDup
+	// opPopUnder removes the value under the top of the stack
+	opPopUnder
+
+	opGetName
+	opDefine
+	// opSetLast sets _ to the value of an expression statement
+	opSetLast
+	// opCheckUnbound stops a name from being assigned when it is already visible
+	opCheckUnbound
+	// opCheckValue stops a value that does not produce anything from being assigned
+	opCheckValue
+	opPublish
+
+	opNegate
+	opNot
+	opAdd
+	opSub
+	opMul
+	opDiv
+	opGreater
+	opLess
+	opEqual
+	opNotEqual
+	opCheckBool
+	opAnd
+
+	opJump
+	opJumpIfFalse
+	opJumpIfTrue
+
+	opText
+	opTable
+	opIndex
+	opClosure
+	opCall
+	// opTailCall calls a function in place of the one running, when the call is in tail position
+	opTailCall
+
+	opCheckPattern
+	opCheckGuard
+	opMatch
+	opEnterScope
+	opLeaveScope
+
+	opUsing
+	opError
+	opReturn
+)
+
+type opDefinition struct {
+	name     string
+	operands int
+}
+
+var opDefinitions = [...]opDefinition{
+	opConstant:     {"Constant", 1},
+	opNil:          {"Nil", 0},
+	opPop:          {"Pop", 0},
+	opDup:          {"Dup", 0},
+	opPopUnder:     {"PopUnder", 0},
+	opGetName:      {"GetName", 1},
+	opDefine:       {"Define", 1},
+	opSetLast:      {"SetLast", 0},
+	opCheckUnbound: {"CheckUnbound", 1},
+	opCheckValue:   {"CheckValue", 1},
+	opPublish:      {"Publish", 1},
+	opNegate:       {"Negate", 1},
+	opNot:          {"Not", 1},
+	opAdd:          {"Add", 1},
+	opSub:          {"Sub", 1},
+	opMul:          {"Mul", 1},
+	opDiv:          {"Div", 1},
+	opGreater:      {"Greater", 1},
+	opLess:         {"Less", 1},
+	opEqual:        {"Equal", 0},
+	opNotEqual:     {"NotEqual", 0},
+	opCheckBool:    {"CheckBool", 1},
+	opAnd:          {"And", 0},
+	opJump:         {"Jump", 1},
+	opJumpIfFalse:  {"JumpIfFalse", 1},
+	opJumpIfTrue:   {"JumpIfTrue", 1},
+	opText:         {"Text", 1},
+	opTable:        {"Table", 1},
+	opIndex:        {"Index", 1},
+	opClosure:      {"Closure", 1},
+	opCall:         {"Call", 1},
+	opTailCall:     {"TailCall", 1},
+	opCheckPattern: {"CheckPattern", 1},
+	opCheckGuard:   {"CheckGuard", 1},
+	opMatch:        {"Match", 2},
+	opEnterScope:   {"EnterScope", 1},
+	opLeaveScope:   {"LeaveScope", 0},
+	opUsing:        {"Using", 1},
+	opError:        {"Error", 1},
+	opReturn:       {"Return", 0},
+}
+
+// makeInstruction encodes an instruction.
+func makeInstruction(op opcode, operands ...int) []byte {
+	instruction := make([]byte, 1+operandWidth*len(operands))
+	instruction[0] = byte(op)
+	for i, operand := range operands {
+		binary.BigEndian.PutUint32(instruction[1+operandWidth*i:], uint32(operand))
+	}
+	return instruction
+}
+
+func readOperand(code []byte, offset int) int {
+	return int(binary.BigEndian.Uint32(code[offset:]))
+}
@@ -0,0 +1,16 @@
+package evaluator
+
+import "testing"
+
+func TestOperandsWiderThanTwoBytes(t *testing.T) {
+	instruction := makeInstruction(opMatch, 70000, 1<<20)
+	if len(instruction) != 1+2*operandWidth {
+		t.Fatalf("expected %d bytes, got %d", 1+2*operandWidth, len(instruction))
+	}
+	if got := readOperand(instruction, 1); got != 70000 {
+		t.Fatalf("expected the first operand to be 70000, got %d", got)
+	}
+	if got := readOperand(instruction, 1+operandWidth); got != 1<<20 {
+		t.Fatalf("expected the second operand to be %d, got %d", 1<<20, got)
+	}
+}
@@ -0,0 +1,593 @@
+package evaluator
+
+import (
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+// chunk is compiled code together with the constants its instructions refer to.
+type chunk struct {
+	code      []byte
+	constants []any
+}
+
+// function is a compiled function declaration. Its parameters take the slots after _
+// in the scope of a call, followed by its rest parameter.
+type function struct {
+	name     string
+	params   []string
+	defaults []bool
+	// restSlot is the slot of the rest parameter, or -1 when there is none
+	restSlot int
+	numSlots int
+	body     *chunk
+}
+
+type boolCheck struct {
+	message string
+	span    token.Span
+}
+
+type tableEntryKind int
+
+const (
+	positionalEntry tableEntryKind = iota
+	namedEntry
+	spreadEntry
+	// computedEntry takes its key from the stack, before its value
+	computedEntry
+)
+
+type tableEntry struct {
+	kind tableEntryKind
+	key  string
+	span token.Span
+}
+
+type tableDesc struct {
+	entries []tableEntry
+	// values is the number of values the entries take from the stack
+	values int
+}
+
+type indexDesc struct {
+	computed      bool
+	key           string
+	subjectName   string
+	subjectSpan   token.Span
+	attributeSpan token.Span
+}
+
+type callArgument struct {
+	name   string
+	spread bool
+	span   token.Span
+}
+
+type callSite struct {
+	args []callArgument
+	span token.Span
+}
+
+type patternKind int
+
+const (
+	literalPattern patternKind = iota
+	bindPattern
+	wildcardPattern
+	tablePattern
+	alternativePattern
+	typePattern
+)
+
+// pattern is a compiled ast.Pattern.
+type pattern struct {
+	kind patternKind
+	// literal is evaluated outside of the scope the pattern binds names in, it is the
+	// type of a type pattern
+	literal *chunk
+	// slot is the slot a bind or type pattern sets, or that of the rest of a table pattern,
+	// which is -1 when there is no name
+	slot    int
+	entries []patternEntry
+	rest    bool
+	// keys are the named keys of a table pattern, and positional is the number of
+	// entries without a key, which take the rest of a table apart
+	keys         []value.Value
+	positional   int
+	alternatives []*pattern
+	span         token.Span
+}
+
+type patternEntry struct {
+	key   value.Value
+	value *pattern
+}
+
+// matchDesc is a pattern matched by opMatch.
+type matchDesc struct {
+	pattern *pattern
+	// arm is set for the pattern of a case arm, which binds names in a new scope of
+	// numSlots slots. A line of a chain binds names in the scope of the chain.
+	arm      bool
+	numSlots int
+}
+
+// compiler turns a program into a chunk for the vm.
+type compiler struct {
+	chunk *chunk
+}
+
+// compile compiles a program that runs in the global scope.
+func compile(program ast.Program) *chunk {
+	c := &compiler{chunk: &chunk{}}
+	c.compileBody(program.Body, true, false)
+	c.emit(opReturn)
+	return c.chunk
+}
+
+func (c *compiler) emit(op opcode, operands ...int) int {
+	position := len(c.chunk.code)
+	c.chunk.code = append(c.chunk.code, makeInstruction(op, operands...)...)
+	return position
+}
+
+func (c *compiler) constant(constant any) int {
+	c.chunk.constants = append(c.chunk.constants, constant)
+	return len(c.chunk.constants) - 1
+}
+
+// emitJump emits a jump whose target is set later by patchJump.
+func (c *compiler) emitJump(op opcode) int {
+	return c.emit(op, 0)
+}
+
+func (c *compiler) patchJump(position int) {
+	copy(c.chunk.code[position+1:], makeInstruction(opJump, len(c.chunk.code))[1:])
+}
+
+// patchMatch sets where the opMatch at position jumps when its pattern does not match.
+func (c *compiler) patchMatch(position int) {
+	copy(c.chunk.code[position+1+operandWidth:], makeInstruction(opJump, len(c.chunk.code))[1:])
+}
+
+func (c *compiler) emitError(err *RuntimeError) {
+	c.emit(opError, c.constant(err))
+}
+
+// withChunk compiles into a new chunk and returns it.
+func (c *compiler) withChunk(compile func()) *chunk {
+	outer := c.chunk
+	c.chunk = &chunk{}
+	compile()
+	c.emit(opReturn)
+	compiled := c.chunk
+	c.chunk = outer
+	return compiled
+}
+
+// compileBody compiles the statements of a program or a block, leaving the value of
+// the last one on the stack. With tail set, the block is in tail position of a function.
+func (c *compiler) compileBody(body []ast.Node, topLevel bool, tail bool) {
+	if len(body) == 0 {
+		c.emit(opNil)
+		return
+	}
+	for i, node := range body {
+		switch node := node.(type) {
+		case ast.AssignmentStatement, ast.FunctionDeclaration:
+			c.compileNode(node)
+		case ast.UsingStatement:
+			if !topLevel {
+				c.emitError(errorAt(node.Span, "a using statement can only be at the top level"))
+			}
+			c.compileNode(node)
+		case ast.PubStatement:
+			if !topLevel {
+				c.emitError(errorAt(node.Span, "a pub statement can only be at the top level"))
+			}
+			c.compileNode(node)
+		default:
+			if tail && i == len(body)-1 {
+				c.compileTail(node)
+			} else {
+				c.compileNode(node)
+			}
+			c.emit(opDup)
+			c.emit(opSetLast)
+		}
+		if i != len(body)-1 {
+			c.emit(opPop)
+		}
+	}
+}
+
+// compileNode compiles node so that it leaves its value on the stack.
+func (c *compiler) compileNode(node ast.Node) {
+	switch node := node.(type) {
+	case ast.NumberLiteral:
+		c.emit(opConstant, c.constant(value.Number{Value: node.Value}))
+	case ast.BooleanLiteral:
+		c.emit(opConstant, c.constant(value.Boolean{Value: node.Value}))
+	case ast.TextLiteral:
+		for _, part := range node.Parts {
+			switch part := part.(type) {
+			case ast.TextPart:
+				c.emit(opConstant, c.constant(value.Text{Value: part.Value}))
+			default:
+				c.compileNode(part)
+			}
+		}
+		c.emit(opText, len(node.Parts))
+	case ast.PrefixExpression:
+		c.compileNode(node.Right)
+		switch node.Operator {
+		case token.NOT:
+			c.emit(opNot, c.constant(node.Right.Range()))
+		case token.MINUS:
+			c.emit(opNegate, c.constant(node.Right.Range()))
+		default:
+			c.emit(opPop)
+			c.emit(opNil)
+		}
+	case ast.InfixExpression:
+		c.compileInfix(node)
+	case ast.Block:
+		c.compileBody(node.Body, false, false)
+	case ast.CaseExpression:
+		c.compileCase(node, false)
+	case ast.AssignmentStatement:
+		c.emit(opCheckUnbound, c.constant(node.Name))
+		c.compileNode(node.Value)
+		c.emit(opCheckValue, c.constant(node.Value.Span))
+		c.emit(opDefine, c.constant(node.Name))
+		c.emit(opNil)
+	case ast.AccessOperator:
+		c.compileNode(node.Subject)
+		desc := &indexDesc{subjectName: ast.SubjectName(node.Subject), subjectSpan: node.Subject.Range(), attributeSpan: node.Attribute.Range()}
+		switch attribute := node.Attribute.(type) {
+		case ast.Identifier:
+			desc.key = attribute.Value
+		case ast.Grouped:
+			desc.computed = true
+			c.compileNode(attribute.Value)
+		default:
+			c.emit(opNil)
+			desc.computed = true
+		}
+		c.emit(opIndex, c.constant(desc))
+	case ast.Identifier:
+		c.emit(opGetName, c.constant(node))
+	case ast.FunctionDeclaration:
+		c.compileFunction(node)
+	case ast.FunctionCall:
+		c.compileCall(node, opCall)
+	case ast.ChainExpression:
+		c.compileChain(node)
+	case ast.TableLiteral:
+		desc := &tableDesc{}
+		for _, entry := range node.Entries {
+			if entry.Computed != nil {
+				desc.entries = append(desc.entries, tableEntry{kind: computedEntry, span: entry.Computed.Span})
+				c.compileNode(entry.Computed.Value)
+				c.compileNode(entry.Value)
+				desc.values += 1
+			} else if entry.Key != nil {
+				desc.entries = append(desc.entries, tableEntry{kind: namedEntry, key: entry.Key.Value})
+				c.compileNode(entry.Value)
+			} else if rest, ok := entry.Value.(ast.RestOperator); ok {
+				desc.entries = append(desc.entries, tableEntry{kind: spreadEntry, span: rest.Span})
+				c.compileNode(rest.Value)
+			} else {
+				desc.entries = append(desc.entries, tableEntry{kind: positionalEntry})
+				c.compileNode(entry.Value)
+			}
+			desc.values += 1
+		}
+		c.emit(opTable, c.constant(desc))
+	case ast.PubStatement:
+		switch pub := node.Public.(type) {
+		case ast.AssignmentStatement:
+			c.compileNode(pub)
+			c.emit(opPop)
+			c.emit(opPublish, c.constant(pub.Name.Value))
+		case ast.FunctionDeclaration:
+			if pub.Name == nil {
+				c.emitError(errorAt(node.Span, "anonymous function could not be made public"))
+				break
+			}
+			c.compileNode(pub)
+			c.emit(opPop)
+			c.emit(opPublish, c.constant(pub.Name.Value))
+		default:
+			c.emitError(errorAt(node.Span, "%T cannot be made public", pub))
+		}
+		c.emit(opNil)
+	case ast.UsingStatement:
+		c.emit(opUsing, c.constant(node))
+		c.emit(opNil)
+	case ast.RestOperator:
+		c.emitError(errorAt(node.Span, "rest operator is not a normal expression and cant be used on its own. "))
+	default:
+		c.emitError(errorAt(node.Range(), "eval error %T", node))
+	}
+}
+
+// compileCall compiles a function call made by op.
+func (c *compiler) compileCall(node ast.FunctionCall, op opcode) {
+	c.compileNode(node.Fn)
+	site := &callSite{span: node.Span}
+	for _, arg := range node.Arguments {
+		argument := callArgument{span: arg.Span}
+		if arg.Name != nil {
+			argument.name = arg.Name.Value
+		}
+		switch argValue := arg.Value.(type) {
+		case ast.RestOperator:
+			argument.spread = true
+			argument.span = argValue.Span
+			c.compileNode(argValue.Value)
+		default:
+			c.compileNode(argValue)
+		}
+		site.args = append(site.args, argument)
+	}
+	c.emit(op, c.constant(site))
+}
+
+// compileTail compiles node in tail position of a function, where a call of a
+// function takes the place of the function it is in.
+func (c *compiler) compileTail(node ast.Node) {
+	switch node := node.(type) {
+	case ast.Block:
+		c.compileBody(node.Body, false, true)
+	case ast.CaseExpression:
+		c.compileCase(node, true)
+	case ast.FunctionCall:
+		c.compileCall(node, opTailCall)
+	default:
+		c.compileNode(node)
+	}
+}
+
+func (c *compiler) compileInfix(node ast.InfixExpression) {
+	switch node.Operator {
+	case token.IS, token.IS_NOT:
+		c.compileNode(node.Left)
+		c.compileNode(node.Right)
+		if node.Operator == token.IS {
+			c.emit(opEqual)
+		} else {
+			c.emit(opNotEqual)
+		}
+		return
+	case token.AND:
+		c.compileNode(node.Left)
+		c.emit(opCheckBool, c.constant(&boolCheck{message: "left arg in and does not eval to a boolean", span: node.Left.Range()}))
+		c.compileNode(node.Right)
+		c.emit(opCheckBool, c.constant(&boolCheck{message: "right arg in and does not eval to a boolean", span: node.Right.Range()}))
+		c.emit(opAnd)
+		return
+	case token.OR:
+		c.compileNode(node.Left)
+		c.emit(opCheckBool, c.constant(&boolCheck{message: "left arg in or does not eval to a boolean", span: node.Left.Range()}))
+		toTrue := c.emitJump(opJumpIfTrue)
+		c.compileNode(node.Right)
+		c.emit(opCheckBool, c.constant(&boolCheck{message: "right arg in or does not eval to a boolean", span: node.Right.Range()}))
+		toEnd := c.emitJump(opJump)
+		c.patchJump(toTrue)
+		c.emit(opConstant, c.constant(value.Boolean{Value: true}))
+		c.patchJump(toEnd)
+		return
+	}
+	c.compileNode(node.Left)
+	c.compileNode(node.Right)
+	switch node.Operator {
+	case token.PLUS:
+		c.emit(opAdd, c.constant(node.OperatorSpan))
+	case token.MINUS:
+		c.emit(opSub, c.constant(node.OperatorSpan))
+	case token.STAR:
+		c.emit(opMul, c.constant(node.OperatorSpan))
+	case token.SLASH:
+		c.emit(opDiv, c.constant(node.OperatorSpan))
+	case token.GREATER_THAN:
+		c.emit(opGreater, c.constant(node.OperatorSpan))
+	case token.LESSER_THAN:
+		c.emit(opLess, c.constant(node.OperatorSpan))
+	default:
+		c.emit(opPop)
+		c.emit(opPop)
+		c.emit(opNil)
+	}
+}
+
+func (c *compiler) compileFunction(node ast.FunctionDeclaration) {
+	fn := &function{restSlot: -1, numSlots: node.Locals}
+	if node.Name != nil {
+		fn.name = node.Name.Value
+	}
+	for _, param := range node.Parameters.Keys() {
+		paramDefault, _ := node.Parameters.Get(param)
+		fn.params = append(fn.params, param)
+		fn.defaults = append(fn.defaults, paramDefault != nil)
+		// defaults are evaluated where the function is declared
+		if paramDefault != nil {
+			c.compileNode(paramDefault)
+		}
+	}
+	if node.Rest != nil {
+		if rest, ok := node.Rest.Value.(ast.Identifier); ok {
+			fn.restSlot = rest.Slot
+		}
+	}
+	fn.body = c.withChunk(func() { c.compileTail(node.Body) })
+
+	c.emit(opClosure, c.constant(fn))
+	if node.Name != nil {
+		c.emit(opDup)
+		c.emit(opDefine, c.constant(*node.Name))
+	}
+}
+
+// compileCase compiles a case expression. The subject stays on the stack while the
+// patterns are tried, and every arm runs in a scope of its own.
+func (c *compiler) compileCase(node ast.CaseExpression, tail bool) {
+	if node.Subject != nil {
+		c.compileNode(node.Subject)
+	}
+	toEnd := []int{}
+	for _, arm := range node.Cases {
+		toNext := -1
+		noMatch := -1
+		if node.Subject == nil {
+			toNext = c.compileConditions(arm.Pattern)
+			c.emit(opEnterScope, arm.Locals)
+		} else {
+			desc := &matchDesc{pattern: c.compilePattern(arm.Pattern), arm: true, numSlots: arm.Locals}
+			noMatch = c.emit(opMatch, c.constant(desc), 0)
+		}
+
+		toLeave := -1
+		if arm.Guard != nil {
+			c.compileNode(arm.Guard)
+			c.emit(opCheckGuard, c.constant(arm.Guard.Range()))
+			toLeave = c.emitJump(opJumpIfFalse)
+		}
+		c.compileBlock(arm.Block, tail)
+		c.emit(opLeaveScope)
+		toEnd = append(toEnd, c.emitJump(opJump))
+
+		// the guard is false
+		if toLeave != -1 {
+			c.patchJump(toLeave)
+			c.emit(opLeaveScope)
+		}
+		if toNext != -1 {
+			c.patchJump(toNext)
+		}
+		if noMatch != -1 {
+			c.patchMatch(noMatch)
+		}
+	}
+
+	// no arm matched
+	if node.Subject != nil {
+		c.emit(opPop)
+	}
+	if node.Default != nil {
+		c.compileBlock(*node.Default, tail)
+	} else {
+		c.emitError(errorAt(node.Span, "No truthy case in case expr"))
+	}
+	toDone := c.emitJump(opJump)
+
+	for _, position := range toEnd {
+		c.patchJump(position)
+	}
+	if node.Subject != nil {
+		c.emit(opPopUnder)
+	}
+	c.patchJump(toDone)
+}
+
+// compileConditions compiles the pattern of an arm of a case without a subject, whose
+// conditions have to be true or false. It returns the jump taken when none of them is true.
+func (c *compiler) compileConditions(pattern ast.Pattern) int {
+	conditions := []ast.Pattern{pattern}
+	if alternatives, ok := pattern.(ast.AlternativePattern); ok {
+		conditions = alternatives.Alternatives
+	}
+	toArm := []int{}
+	for _, condition := range conditions[:len(conditions)-1] {
+		c.compileCondition(condition.(ast.LiteralPattern))
+		toArm = append(toArm, c.emitJump(opJumpIfTrue))
+	}
+	c.compileCondition(conditions[len(conditions)-1].(ast.LiteralPattern))
+	toNext := c.emitJump(opJumpIfFalse)
+	for _, position := range toArm {
+		c.patchJump(position)
+	}
+	return toNext
+}
+
+func (c *compiler) compileCondition(condition ast.LiteralPattern) {
+	c.compileNode(condition.Value)
+	c.emit(opCheckPattern, c.constant(condition.Span))
+}
+
+// compilePattern compiles a pattern matched by opMatch.
+func (c *compiler) compilePattern(node ast.Pattern) *pattern {
+	switch node := node.(type) {
+	case ast.LiteralPattern:
+		return &pattern{kind: literalPattern, literal: c.withChunk(func() { c.compileNode(node.Value) })}
+	case ast.BindingPattern:
+		return &pattern{kind: bindPattern, slot: node.Name.Slot}
+	case ast.WildcardPattern:
+		return &pattern{kind: wildcardPattern}
+	case ast.TypePattern:
+		compiled := &pattern{kind: typePattern, literal: c.withChunk(func() { c.compileNode(node.Type) }), slot: -1, span: node.Type.Range()}
+		if node.Name != nil {
+			compiled.slot = node.Name.Slot
+		}
+		return compiled
+	case ast.AlternativePattern:
+		compiled := &pattern{kind: alternativePattern}
+		for _, alternative := range node.Alternatives {
+			compiled.alternatives = append(compiled.alternatives, c.compilePattern(alternative))
+		}
+		return compiled
+	case ast.TablePattern:
+		compiled := &pattern{kind: tablePattern, slot: -1, rest: node.Rest != nil}
+		for _, entry := range node.Entries {
+			var key value.Value
+			if entry.Key != nil {
+				key = value.TableKey{Value: entry.Key.Value}
+				compiled.keys = append(compiled.keys, key)
+			} else {
+				key = value.Number{Value: float64(compiled.positional)}
+				compiled.positional++
+			}
+			compiled.entries = append(compiled.entries, patternEntry{key: key, value: c.compilePattern(entry.Value)})
+		}
+		if node.Rest != nil && node.Rest.Name != nil {
+			compiled.slot = node.Rest.Name.Slot
+		}
+		return compiled
+	}
+	panic("compile error: unknown pattern")
+}
+
+// compileChain compiles a chain expression, which runs in a scope of its own. The value
+// of a line stays on the stack while it is matched, and is the value of the chain when
+// it does not match.
+func (c *compiler) compileChain(node ast.ChainExpression) {
+	c.emit(opEnterScope, node.Locals)
+	if len(node.Lines) == 0 {
+		c.emit(opNil)
+	}
+	toEnd := []int{}
+	for i, line := range node.Lines {
+		c.compileNode(line.Value)
+		desc := &matchDesc{pattern: c.compilePattern(line.Pattern)}
+		toEnd = append(toEnd, c.emit(opMatch, c.constant(desc), 0))
+		c.emit(opDup)
+		c.emit(opSetLast)
+		if i < len(node.Lines)-1 {
+			c.emit(opPop)
+		}
+	}
+	for _, position := range toEnd {
+		c.patchMatch(position)
+	}
+	c.emit(opLeaveScope)
+}
+
+func (c *compiler) compileBlock(block ast.Block, tail bool) {
+	if tail {
+		c.compileTail(block)
+	} else {
+		c.compileNode(block)
+	}
+}
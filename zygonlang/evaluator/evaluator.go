@@ -1,11 +1,10 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
-	"os"
-	"reflect"
+	"runtime"
 	"strings"
-	"thechosenzendro/zygonlang/zygonlang/analyzer"
 	"thechosenzendro/zygonlang/zygonlang/ast"
 	"thechosenzendro/zygonlang/zygonlang/builtin"
 	"thechosenzendro/zygonlang/zygonlang/token"
@@ -17,7 +16,44 @@ import (
 
 var builtinLib = builtin.BuiltinLib()
 
-func Eval(node ast.Node, env *value.Environment) value.Value {
+// RuntimeError is a problem that happened while running the program.
+// Err is set when the problem came from a builtin function.
+type RuntimeError struct {
+	token.Span
+	Message string
+	Err     error
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Start.Line, e.Start.Column, e.Message)
+}
+func (e *RuntimeError) Msg() string   { return e.Message }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+func errorAt(span token.Span, format string, args ...any) *RuntimeError {
+	return &RuntimeError{Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// recoverError stops an error raised while evaluating and stores it in err.
+// Go runtime errors are bugs in the evaluator, so they are not stopped.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		evalErr, ok := r.(error)
+		if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+			panic(r)
+		}
+		*err = evalErr
+	}
+}
+
+// evaluator evaluates the code of one module.
+type evaluator struct {
+	loader *Loader
+	// file is the path of the module, or "" when the code is not from a file
+	file string
+}
+
+func (e *evaluator) eval(node ast.Node, env *value.Environment) value.Value {
 	switch node := node.(type) {
 	case ast.Program:
 		var res value.Value
@@ -30,19 +66,19 @@ func Eval(node ast.Node, env *value.Environment) value.Value {
 			case ast.PubStatement:
 			default:
 				run = false
-				res = Eval(nd, env)
-				env.Set("_", res)
+				res = e.eval(nd, env)
+				setLast(env, res)
 			}
 			if run {
-				res = Eval(nd, env)
+				res = e.eval(nd, env)
 			}
 
 		}
 		return res
 	case ast.NumberLiteral:
-		return value.Number(node)
+		return value.Number{Value: node.Value}
 	case ast.BooleanLiteral:
-		return value.Boolean(node)
+		return value.Boolean{Value: node.Value}
 	case ast.TextLiteral:
 		str := ""
 		for _, part := range node.Parts {
@@ -50,45 +86,55 @@ func Eval(node ast.Node, env *value.Environment) value.Value {
 			case ast.TextPart:
 				str = str + part.Value
 			default:
-				str = str + Eval(part, env).Inspect()
+				str = str + e.eval(part, env).Inspect()
 			}
 		}
 		return value.Text{Value: str}
 	case ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := e.eval(node.Right, env)
 		switch node.Operator {
 		case token.NOT:
 			switch right := right.(type) {
 			case value.Boolean:
 				return value.Boolean{Value: !right.Value}
 			default:
-				panic("non boolean passed to not")
+				panic(errorAt(node.Right.Range(), "non boolean passed to not"))
 			}
 		case token.MINUS:
 			switch right := right.(type) {
 			case value.Number:
 				return value.Number{Value: -right.Value}
+			default:
+				panic(errorAt(node.Right.Range(), "non number passed to -"))
 			}
 		}
 	case ast.InfixExpression:
 		switch node.Operator {
 		case token.IS:
-			return value.Boolean{Value: reflect.DeepEqual(Eval(node.Left, env), Eval(node.Right, env))}
+			return value.Boolean{Value: value.Equal(e.eval(node.Left, env), e.eval(node.Right, env))}
 		case token.IS_NOT:
-			return value.Boolean{Value: !reflect.DeepEqual(Eval(node.Left, env), Eval(node.Right, env))}
+			return value.Boolean{Value: !value.Equal(e.eval(node.Left, env), e.eval(node.Right, env))}
 		case token.AND:
-			return value.Boolean{Value: Eval(node.Left, env).(value.Boolean).Value && Eval(node.Right, env).(value.Boolean).Value}
+			left, ok := e.eval(node.Left, env).(value.Boolean)
+			if !ok {
+				panic(errorAt(node.Left.Range(), "left arg in and does not eval to a boolean"))
+			}
+			right, ok := e.eval(node.Right, env).(value.Boolean)
+			if !ok {
+				panic(errorAt(node.Right.Range(), "right arg in and does not eval to a boolean"))
+			}
+			return value.Boolean{Value: left.Value && right.Value}
 		case token.OR:
-			left := Eval(node.Left, env)
+			left := e.eval(node.Left, env)
 			if left.Type() != types.BOOL {
-				panic("left arg in or does not eval to a boolean")
+				panic(errorAt(node.Left.Range(), "left arg in or does not eval to a boolean"))
 			}
 			if left.Inspect() == "true" {
 				return value.Boolean{Value: true}
 			}
-			right := Eval(node.Right, env)
+			right := e.eval(node.Right, env)
 			if right.Type() != types.BOOL {
-				panic("right arg in or does not eval to a boolean")
+				panic(errorAt(node.Right.Range(), "right arg in or does not eval to a boolean"))
 			}
 			if right.Inspect() != "true" {
 				return value.Boolean{Value: false}
@@ -96,25 +142,25 @@ func Eval(node ast.Node, env *value.Environment) value.Value {
 
 			return value.Boolean{Value: true}
 		}
-		left := Eval(node.Left, env)
-		right := Eval(node.Right, env)
-
-		if left.Type() == types.NUMBER && right.Type() == types.NUMBER {
-			switch node.Operator {
-			case token.PLUS:
-				return value.Number{Value: left.(value.Number).Value + right.(value.Number).Value}
-			case token.MINUS:
-				return value.Number{Value: left.(value.Number).Value - right.(value.Number).Value}
-			case token.STAR:
-				return value.Number{Value: left.(value.Number).Value * right.(value.Number).Value}
-			case token.SLASH:
-				return value.Number{Value: left.(value.Number).Value / right.(value.Number).Value}
-			case token.GREATER_THAN:
-				return value.Boolean{Value: left.(value.Number).Value > right.(value.Number).Value}
-			case token.LESSER_THAN:
-				return value.Boolean{Value: left.(value.Number).Value < right.(value.Number).Value}
+		left := e.eval(node.Left, env)
+		right := e.eval(node.Right, env)
 
-			}
+		if left == nil || right == nil || left.Type() != types.NUMBER || right.Type() != types.NUMBER {
+			panic(errorAt(node.OperatorSpan, "expected two numbers, got %s and %s", value.TypeName(left), value.TypeName(right)))
+		}
+		switch node.Operator {
+		case token.PLUS:
+			return value.Number{Value: left.(value.Number).Value + right.(value.Number).Value}
+		case token.MINUS:
+			return value.Number{Value: left.(value.Number).Value - right.(value.Number).Value}
+		case token.STAR:
+			return value.Number{Value: left.(value.Number).Value * right.(value.Number).Value}
+		case token.SLASH:
+			return value.Number{Value: left.(value.Number).Value / right.(value.Number).Value}
+		case token.GREATER_THAN:
+			return value.Boolean{Value: left.(value.Number).Value > right.(value.Number).Value}
+		case token.LESSER_THAN:
+			return value.Boolean{Value: left.(value.Number).Value < right.(value.Number).Value}
 		}
 
 	case ast.Block:
@@ -125,436 +171,583 @@ func Eval(node ast.Node, env *value.Environment) value.Value {
 			case ast.AssignmentStatement:
 			case ast.FunctionDeclaration:
 			case ast.UsingStatement:
-				panic("a using statement can only be at the top level")
+				panic(errorAt(nd.Span, "a using statement can only be at the top level"))
 			case ast.PubStatement:
-				panic("a pub statement can only be at the top level")
+				panic(errorAt(nd.Span, "a pub statement can only be at the top level"))
 			default:
 				run = false
-				res = Eval(nd, env)
-				env.Set("_", res)
+				res = e.eval(nd, env)
+				setLast(env, res)
 			}
 			if run {
-				res = Eval(nd, env)
+				res = e.eval(nd, env)
 			}
 		}
 		return res
 
 	case ast.CaseExpression:
-		var subject value.Value
-		if node.Subject != nil {
-			subject = Eval(node.Subject, env)
-		}
-	caseLoop:
-		for _, _case := range node.Cases {
-			var patternResult value.Value
-			var patternEnviron value.Environment = value.Environment{Store: map[string]value.Value{}, Outer: env}
-
-			if subject == nil {
-				patternResult = Eval(_case.Pattern, env)
-
-			} else {
-				switch _pattern := _case.Pattern.(type) {
-				case ast.TableLiteral:
-					if subject.Type() == types.TABLE {
-						ind := 0
-						patternEnviron = value.Environment{Store: map[string]value.Value{}, Outer: env}
-						usedKeys := map[value.Value]string{}
-						for _, entry := range _pattern.Entries {
-							var key value.Value
-							if entry.Key == nil {
-								key = value.Number{Value: float64(ind)}
-								ind += 1
-							} else {
-								key = value.TableKey(*entry.Key)
-							}
-							val, ok := subject.(value.Table).Entries.Get(key)
-							if ok {
-								usedKeys[key] = ""
-								switch entryValue := entry.Value.(type) {
-								case ast.Identifier:
-									patternEnviron.Set(entryValue.Value, val)
-									patternResult = value.Boolean{Value: true}
-
-								case ast.RestOperator:
-									if entryValue.Value == nil && subject.(value.Table).Entries.Len() < len(node.Cases) {
-										if patternResult.(value.Boolean).Value {
-											break caseLoop
-										}
-									} else {
-										delete(usedKeys, key)
-										table := value.Table{Entries: orderedmap.NewOrderedMap[value.Value, value.Value]()}
-										i := 0
-										for _, key := range subject.(value.Table).Entries.Keys() {
-											if _, ok := usedKeys[key]; !ok {
-												if key.Type() == types.NUMBER {
-													key = value.Number{Value: float64(i)}
-													i += 1
-												}
-												value, _ := subject.(value.Table).Entries.Get(key)
-												table.Entries.Set(key, value)
-											}
-										}
-										patternEnviron.Set(entryValue.Value.(ast.Identifier).Value, table)
-									}
-								default:
-									if !reflect.DeepEqual(val, Eval(entry.Value, env)) {
-										patternResult = value.Boolean{Value: false}
-										break caseLoop
-									} else {
-										patternResult = value.Boolean{Value: true}
-									}
-								}
-							} else {
-								patternResult = value.Boolean{Value: false}
-								break caseLoop
-							}
-						}
-					}
-				default:
-					patternEnviron = value.Environment{Store: map[string]value.Value{}, Outer: env}
-					pattern := Eval(_pattern, env)
-					patternResult = value.Boolean{Value: reflect.DeepEqual(subject, pattern)}
-				}
-			}
-			if patternResult == nil {
-				panic("pattern does not eval to anything")
-			}
-			if patternResult.Type() != types.BOOL {
-				panic("pattern result is not a boolean")
-			}
-			if patternResult.Inspect() == "true" {
-				return Eval(_case.Block, &patternEnviron)
-			}
-		}
-		if node.Default != nil {
-			return Eval(*node.Default, env)
-		}
-		panic("No truthy case in case expr")
+		block, blockEnv := e.selectCase(node, env)
+		return e.eval(block, blockEnv)
 	case ast.AssignmentStatement:
-		if _, ok := env.Get(node.Name.Value); !ok {
-			val := Eval(node.Value, env)
+		if _, ok := lookup(node.Name, env); !ok {
+			val := e.eval(node.Value, env)
 			if val == nil {
-				panic("value does not produce anything")
+				panic(errorAt(node.Value.Span, "value does not produce anything"))
 			}
-			env.Set(node.Name.Value, val)
+			define(node.Name, env, val)
 			return nil
 		} else {
-			panic(fmt.Sprintf("Cannot reassign identifier %s", node.Name.Value))
+			panic(errorAt(node.Name.Span, "Cannot reassign identifier %s", node.Name.Value))
 		}
 	case ast.AccessOperator:
-		subject := Eval(node.Subject, env)
+		subject := e.eval(node.Subject, env)
 		var index value.Value
 		switch attribute := node.Attribute.(type) {
 		case ast.Identifier:
-			index = value.TableKey(attribute)
+			index = value.TableKey{Value: attribute.Value}
 		case ast.Grouped:
-			index = Eval(attribute.Value, env)
+			index = e.eval(attribute.Value, env)
 		}
 		switch subject := subject.(type) {
 		case value.Table:
 			val, ok := subject.Entries.Get(index)
 			if !ok {
-				panic(fmt.Sprintf("bad index %s on table %s", index.Inspect(), subject.Inspect()))
+				panic(errorAt(node.Attribute.Range(), "%s does not have the \"%s\" attribute", ast.SubjectName(node.Subject), index.Inspect()))
 			}
 			return val
 
 		default:
-			panic(fmt.Sprintf("Cannot index type %T", subject))
+			panic(errorAt(node.Subject.Range(), "Cannot index type %T", subject))
 		}
 	case ast.Identifier:
-		val, ok := env.Get(node.Value)
+		val, ok := lookup(node, env)
 		if !ok {
-			panic(fmt.Sprintf("identifier %s not found", node.Value))
+			panic(errorAt(node.Span, "\"%s\" is not defined", node.Value))
 		}
 		return val
 	case ast.FunctionDeclaration:
-		fn := value.Function{Parameters: orderedmap.NewOrderedMap[value.TableKey, value.Value](), Body: node.Body, Rest: node.Rest, Env: env}
+		fn := value.Function{Parameters: orderedmap.NewOrderedMap[value.TableKey, value.Value](), Body: node.Body, Rest: node.Rest, Env: env, Locals: node.Locals}
 
 		for _, name := range node.Parameters.Keys() {
 			param_default, _ := node.Parameters.Get(name)
 			if param_default == nil {
-				fn.Parameters.Set(value.TableKey(name), nil)
+				fn.Parameters.Set(value.TableKey{Value: name}, nil)
 			} else {
-				fn.Parameters.Set(value.TableKey(name), Eval(param_default, env))
+				fn.Parameters.Set(value.TableKey{Value: name}, e.eval(param_default, env))
 			}
 
 		}
 		if node.Name != nil {
-			env.Set(node.Name.Value, fn)
+			define(*node.Name, env, fn)
 		}
 		return fn
 	case ast.FunctionCall:
-		fn := Eval(node.Fn, env)
-		switch function := fn.(type) {
-		case value.Function:
-			funcEnviron := &value.Environment{Store: make(map[string]value.Value), Outer: function.Env}
-			i := 0
-			for _, name := range function.Parameters.Keys() {
-				param_default, _ := function.Parameters.Get(name)
-				if len(node.Arguments) > i {
-					arg := node.Arguments[i]
-					switch argValue := arg.Value.(type) {
-					case ast.RestOperator:
-						_rest := Eval(argValue.Value, env)
-						if _rest.Type() != types.TABLE {
-							panic(fmt.Sprintf("cannot spread %T", _rest))
-						}
-						rest := _rest.(value.Table)
-						for _, key := range rest.Entries.Keys() {
-							entryValue, _ := rest.Entries.Get(key)
-							if key != nil {
-								if _, ok := function.Parameters.Get(key.(value.TableKey)); ok {
-									funcEnviron.Set(key.(value.TableKey).Value, entryValue)
-								} else {
-									panic(fmt.Sprintf("Cannot spread items with names that arent in the parameters (%s)", key.Inspect()))
-								}
-							} else {
-								panic("Cannot spread items without names")
-							}
-						}
-					default:
-						if arg.Name == nil {
-							arg.Name = &ast.Identifier{Value: name.Value}
-						}
-						var val value.Value = Eval(arg.Value, env)
-						if arg.Value == nil {
-							val = param_default
-						}
-						funcEnviron.Set(arg.Name.Value, val)
-
-					}
-				} else {
-					name := &name
-					value := param_default
-					if value == nil {
-						panic(fmt.Sprintf("no default for %s", name))
-					}
-					funcEnviron.Set(name.Value, value)
-				}
-				i += 1
+		return e.call(node, env, false)
+	case ast.ChainExpression:
+		chainEnv := &value.Environment{Slots: make([]value.Value, node.Locals), Outer: env}
+		var result value.Value
+		for _, line := range node.Lines {
+			result = e.eval(line.Value, chainEnv)
+			if !e.match(line.Pattern, result, chainEnv, chainEnv) {
+				return result
 			}
-			if function.Rest != nil && function.Parameters.Len() < len(node.Arguments) {
-				rest := value.Table{Entries: orderedmap.NewOrderedMap[value.Value, value.Value]()}
-				ind := 0
-				for _, arg := range node.Arguments[i:] {
-					switch argValue := arg.Value.(type) {
-					case ast.RestOperator:
-						_rest := Eval(argValue.Value, env)
-						if _rest.Type() != types.TABLE {
-							panic(fmt.Sprintf("cannot spread %T", _rest))
-						}
-						rest := _rest.(value.Table)
-						for _, key := range rest.Entries.Keys() {
-							entryValue, _ := rest.Entries.Get(key)
-							if key != nil {
-								if _, ok := function.Parameters.Get(key.(value.TableKey)); ok {
-									funcEnviron.Set(key.(value.TableKey).Value, entryValue)
-								} else {
-									panic("Cannot spread items with names that arent in the parameters")
-								}
-							} else {
-								panic("Cannot spread items without names")
-							}
-						}
-					default:
-						if arg.Name != nil {
-							rest.Entries.Set(value.TableKey(*arg.Name), Eval(arg.Value, env))
-						} else {
-							rest.Entries.Set(value.Number{Value: float64(ind)}, Eval(arg.Value, env))
-							ind += 1
-						}
-					}
-				}
-				funcEnviron.Set(function.Rest.Value.(ast.Identifier).Value, rest)
-			}
-			return Eval(function.Body, funcEnviron)
-
-		case value.BuiltinFunction:
-			i := 0
-			funcEnviron := map[string]value.Value{}
-			for _, name := range function.Contract.Parameters.Keys() {
-				param_default, _ := function.Contract.Parameters.Get(name)
-				if len(node.Arguments) > i {
-					arg := node.Arguments[i]
-					switch argValue := arg.Value.(type) {
-					case ast.RestOperator:
-						_rest := Eval(argValue.Value, env)
-						if _rest.Type() != types.TABLE {
-							panic(fmt.Sprintf("cannot spread %T", _rest))
-						}
-						rest := _rest.(value.Table)
-						for _, key := range rest.Entries.Keys() {
-							entryValue, _ := rest.Entries.Get(key)
-							if key != nil {
-								if _, ok := function.Contract.Parameters.Get(key.(value.TableKey)); ok {
-									funcEnviron[key.(value.TableKey).Value] = entryValue
-								} else {
-									panic(fmt.Sprintf("Cannot spread items with names that arent in the parameters (%s)", key.Inspect()))
-								}
-							} else {
-								panic("Cannot spread items without names")
-							}
-						}
-					default:
-						if arg.Name == nil {
-							arg.Name = &ast.Identifier{Value: name.Value}
-						}
-						var val value.Value = Eval(arg.Value, env)
-						if arg.Value == nil {
-							val = param_default
-						}
-						funcEnviron[arg.Name.Value] = val
-
-					}
-				} else {
-					name := &name
-					value := param_default
-					if value == nil {
-						panic(fmt.Sprintf("no default for %s", name))
-					}
-					funcEnviron[name.Value] = value
-				}
-				i += 1
-			}
-			if function.Contract.Rest != nil && function.Contract.Parameters.Len() < len(node.Arguments) {
-				rest := value.Table{Entries: orderedmap.NewOrderedMap[value.Value, value.Value]()}
-				ind := 0
-				for _, arg := range node.Arguments[i:] {
-					switch argValue := arg.Value.(type) {
-					case ast.RestOperator:
-						_rest := Eval(argValue.Value, env)
-						if _rest.Type() != types.TABLE {
-							panic(fmt.Sprintf("cannot spread %T", _rest))
-						}
-						rest := _rest.(value.Table)
-						for _, key := range rest.Entries.Keys() {
-							entryValue, _ := rest.Entries.Get(key)
-							if key != nil {
-								if _, ok := function.Contract.Parameters.Get(key.(value.TableKey)); ok {
-									funcEnviron[key.(value.TableKey).Value] = entryValue
-								} else {
-									panic("Cannot spread items with names that arent in the parameters")
-								}
-							} else {
-								panic("Cannot spread items without names")
-							}
-						}
-					default:
-						if arg.Name != nil {
-							rest.Entries.Set(value.TableKey(*arg.Name), Eval(arg.Value, env))
-						} else {
-							rest.Entries.Set(value.Number{Value: float64(ind)}, Eval(arg.Value, env))
-							ind += 1
-						}
-					}
-				}
-				funcEnviron[function.Contract.Rest.Value.(ast.Identifier).Value] = rest
-			}
-			return function.Fn(funcEnviron)
-
+			setLast(chainEnv, result)
 		}
+		return result
 	case ast.TableLiteral:
-		entries := orderedmap.NewOrderedMap[value.Value, value.Value]()
+		entries := value.NewTable()
 		index := -1
 		for _, entry := range node.Entries {
-			if entry.Key == nil {
+			if entry.Computed != nil {
+				key := e.eval(entry.Computed.Value, env)
+				if key == nil {
+					panic(errorAt(entry.Computed.Span, "table keys cannot be nothing"))
+				}
+				entries = entries.Set(key, e.eval(entry.Value, env))
+			} else if entry.Key == nil {
 				switch val := entry.Value.(type) {
 				case ast.RestOperator:
-					_table := Eval(val.Value, env)
+					_table := e.eval(val.Value, env)
 					if _table.Type() != types.TABLE {
-						panic("cannot spread non table values")
+						panic(errorAt(val.Span, "cannot spread non table values"))
 					}
 					table := _table.(value.Table)
-					for _, key := range table.Entries.Keys() {
-						entryValue, _ := table.Entries.Get(key)
-						switch key := key.(type) {
-						case value.TableKey:
-							entries.Set(key, entryValue)
-						case value.Number:
-							index += 1
-							entries.Set(value.Number{Value: float64(index)}, entryValue)
-						}
-					}
+					entries, index = table.SpreadInto(entries, index)
 				default:
 					index += 1
-					entries.Set(value.Number{Value: float64(index)}, Eval(entry.Value, env))
+					entries = entries.Set(value.Number{Value: float64(index)}, e.eval(entry.Value, env))
 				}
 			} else {
-				entries.Set(value.TableKey{Value: entry.Key.Value}, Eval(entry.Value, env))
+				entries = entries.Set(value.TableKey{Value: entry.Key.Value}, e.eval(entry.Value, env))
 			}
 		}
-		return value.Table{Entries: entries}
+		return entries
 	case ast.PubStatement:
 		switch pub := node.Public.(type) {
 		case ast.AssignmentStatement:
-			Eval(pub, env)
+			e.eval(pub, env)
 			env.Set("pub "+pub.Name.Value, env.Store[pub.Name.Value])
 		case ast.FunctionDeclaration:
 			if pub.Name != nil {
-				Eval(pub, env)
+				e.eval(pub, env)
 				env.Set("pub "+pub.Name.Value, env.Store[pub.Name.Value])
 			} else {
-				panic("anonymous function could not be made public")
+				panic(errorAt(node.Span, "anonymous function could not be made public"))
 			}
 		default:
-			panic(fmt.Sprintf("%T cannot be made public", pub))
+			panic(errorAt(node.Span, "%T cannot be made public", pub))
 		}
 	case ast.UsingStatement:
-		libRoot := "./lib"
+		useModules(e.loader, e.file, node, env)
+	case ast.RestOperator:
+		panic(errorAt(node.Span, "rest operator is not a normal expression and cant be used on its own. "))
+	default:
+		panic(errorAt(node.Range(), "eval error %T", node))
+	}
+	return nil
+}
 
-		for _, module := range node.Modules {
+// selectCase returns the block of the first arm of a case expression whose pattern
+// matches, or its default block, with the environment to evaluate it in.
+func (e *evaluator) selectCase(node ast.CaseExpression, env *value.Environment) (ast.Block, *value.Environment) {
+	var subject value.Value
+	if node.Subject != nil {
+		subject = e.eval(node.Subject, env)
+	}
+	for _, _case := range node.Cases {
+		arm := &value.Environment{Slots: make([]value.Value, _case.Locals), Outer: env}
+		var matched bool
+		if node.Subject == nil {
+			matched = e.condition(_case.Pattern, env)
+		} else {
+			matched = e.match(_case.Pattern, subject, env, arm)
+		}
+		if matched && e.guard(_case.Guard, arm) {
+			return _case.Block, arm
+		}
+	}
+	if node.Default != nil {
+		return *node.Default, env
+	}
+	panic(errorAt(node.Span, "No truthy case in case expr"))
+}
 
-			if builtin, ok := builtinLib.Get(module.Module); ok {
-				unwrap(module.Module, value.Table{Entries: builtin}, env)
-				for _, symbol := range module.Symbols {
-					v, _ := builtin.Get(value.TableKey(symbol))
-					env.Set(symbol.Value, v)
-				}
+// condition tells whether the pattern of an arm of a case without a subject is true.
+func (e *evaluator) condition(pattern ast.Pattern, env *value.Environment) bool {
+	if alternatives, ok := pattern.(ast.AlternativePattern); ok {
+		for _, alternative := range alternatives.Alternatives {
+			if e.condition(alternative, env) {
+				return true
+			}
+		}
+		return false
+	}
+	literal := pattern.(ast.LiteralPattern)
+	result := e.eval(literal.Value, env)
+	if result == nil {
+		panic(errorAt(literal.Span, "pattern does not eval to anything"))
+	}
+	if result.Type() != types.BOOL {
+		panic(errorAt(literal.Span, "pattern result is not a boolean"))
+	}
+	return result.Inspect() == "true"
+}
 
+// guard tells whether the guard of an arm lets it run, once its pattern matched.
+func (e *evaluator) guard(guard ast.Expression, arm *value.Environment) bool {
+	if guard == nil {
+		return true
+	}
+	result := e.eval(guard, arm)
+	if result == nil {
+		panic(errorAt(guard.Range(), "guard does not eval to anything"))
+	}
+	if result.Type() != types.BOOL {
+		panic(errorAt(guard.Range(), "guard result is not a boolean"))
+	}
+	return result.Inspect() == "true"
+}
+
+// match tells whether val matches pattern, setting the names the pattern binds in arm.
+// Literals are evaluated in env, where the pattern is.
+func (e *evaluator) match(pattern ast.Pattern, val value.Value, env *value.Environment, arm *value.Environment) bool {
+	switch pattern := pattern.(type) {
+	case ast.LiteralPattern:
+		return value.Equal(val, e.eval(pattern.Value, env))
+	case ast.BindingPattern:
+		arm.SetAt(pattern.Name.Slot, val)
+		return true
+	case ast.WildcardPattern:
+		return true
+	case ast.TypePattern:
+		typ, ok := e.eval(pattern.Type, env).(value.Type)
+		if !ok {
+			panic(errorAt(pattern.Type.Range(), "type pattern does not eval to a type"))
+		}
+		if valType, ok := value.TypeOf(val); !ok || valType != typ {
+			return false
+		}
+		if pattern.Name != nil {
+			arm.SetAt(pattern.Name.Slot, val)
+		}
+		return true
+	case ast.AlternativePattern:
+		for _, alternative := range pattern.Alternatives {
+			if e.match(alternative, val, env, arm) {
+				return true
+			}
+		}
+		return false
+	case ast.TablePattern:
+		table, ok := val.(value.Table)
+		if !ok {
+			return false
+		}
+		keys := []value.Value{}
+		positional := 0
+		for _, entry := range pattern.Entries {
+			var key value.Value = value.Number{Value: float64(positional)}
+			if entry.Key != nil {
+				key = value.TableKey{Value: entry.Key.Value}
+				keys = append(keys, key)
 			} else {
-				var e *value.Environment
-				var err error
-				projectRoot := "./examples"
-				_, e, err = getModule(projectRoot + getModPath(module.Module))
-				if err != nil {
-					_, e, err = getModule(libRoot + getModPath(module.Module))
-					if err != nil {
-						panic(err)
+				positional += 1
+			}
+			entryValue, ok := table.Entries.Get(key)
+			if !ok || !e.match(entry.Value, entryValue, env, arm) {
+				return false
+			}
+		}
+		if pattern.Rest == nil {
+			return table.Entries.Len() == len(pattern.Entries)
+		}
+		if pattern.Rest.Name != nil {
+			arm.SetAt(pattern.Rest.Name.Slot, table.Rest(keys, positional))
+		}
+		return true
+	}
+	panic(errorAt(pattern.Range(), "match error %T", pattern))
+}
+
+// call evaluates a function call. In tail position the call of a function is not
+// made, it is returned as a tailCall for the function it is in to make.
+func (e *evaluator) call(node ast.FunctionCall, env *value.Environment, tail bool) value.Value {
+	fn := e.eval(node.Fn, env)
+	switch function := fn.(type) {
+	case value.Function:
+		funcEnviron := &value.Environment{Slots: make([]value.Value, function.Locals), Outer: function.Env}
+		i := 0
+		for _, name := range function.Parameters.Keys() {
+			param_default, _ := function.Parameters.Get(name)
+			if len(node.Arguments) > i {
+				arg := node.Arguments[i]
+				switch argValue := arg.Value.(type) {
+				case ast.RestOperator:
+					_rest := e.eval(argValue.Value, env)
+					if _rest.Type() != types.TABLE {
+						panic(errorAt(argValue.Span, "cannot spread %T", _rest))
+					}
+					rest := _rest.(value.Table)
+					for _, key := range rest.Entries.Keys() {
+						entryValue, _ := rest.Entries.Get(key)
+						if key, ok := key.(value.TableKey); ok {
+							if _, ok := function.Parameters.Get(key); ok {
+								setParameter(function, funcEnviron, key.Value, entryValue)
+							} else {
+								panic(errorAt(argValue.Span, "Cannot spread items with names that arent in the parameters (%s)", key.Inspect()))
+							}
+						} else {
+							panic(errorAt(argValue.Span, "Cannot spread items without names"))
+						}
 					}
+				default:
+					if arg.Name == nil {
+						arg.Name = &ast.Identifier{Value: name.Value}
+					}
+					var val value.Value = e.eval(arg.Value, env)
+					if arg.Value == nil {
+						val = param_default
+					}
+					setParameter(function, funcEnviron, arg.Name.Value, val)
+
 				}
-				pubTable := publicToTable(e)
-				unwrap(module.Module, pubTable, env)
-				for _, symbol := range module.Symbols {
-					if val, ok := e.Get("pub " + symbol.Value); ok {
-						env.Set(symbol.Value, val)
+			} else {
+				name := &name
+				value := param_default
+				if value == nil {
+					panic(errorAt(node.Span, "no default for %s", name.Value))
+				}
+				setParameter(function, funcEnviron, name.Value, value)
+			}
+			i += 1
+		}
+		if function.Rest != nil && function.Parameters.Len() < len(node.Arguments) {
+			rest := value.NewTable()
+			ind := 0
+			for _, arg := range node.Arguments[i:] {
+				switch argValue := arg.Value.(type) {
+				case ast.RestOperator:
+					_rest := e.eval(argValue.Value, env)
+					if _rest.Type() != types.TABLE {
+						panic(errorAt(argValue.Span, "cannot spread %T", _rest))
+					}
+					rest := _rest.(value.Table)
+					for _, key := range rest.Entries.Keys() {
+						entryValue, _ := rest.Entries.Get(key)
+						if key, ok := key.(value.TableKey); ok {
+							if _, ok := function.Parameters.Get(key); ok {
+								setParameter(function, funcEnviron, key.Value, entryValue)
+							} else {
+								panic(errorAt(argValue.Span, "Cannot spread items with names that arent in the parameters"))
+							}
+						} else {
+							panic(errorAt(argValue.Span, "Cannot spread items without names"))
+						}
+					}
+				default:
+					if arg.Name != nil {
+						rest = rest.Set(value.TableKey{Value: arg.Name.Value}, e.eval(arg.Value, env))
+					} else {
+						rest = rest.Set(value.Number{Value: float64(ind)}, e.eval(arg.Value, env))
+						ind += 1
 					}
 				}
+			}
+			funcEnviron.SetAt(function.Rest.Value.(ast.Identifier).Slot, rest)
+		}
+		if tail {
+			return &tailCall{body: function.Body, env: funcEnviron}
+		}
+		return e.evalFunction(function.Body, funcEnviron)
+
+	case value.BuiltinFunction:
+		i := 0
+		funcEnviron := map[string]value.Value{}
+		for _, name := range function.Contract.Parameters.Keys() {
+			param_default, _ := function.Contract.Parameters.Get(name)
+			if len(node.Arguments) > i {
+				arg := node.Arguments[i]
+				switch argValue := arg.Value.(type) {
+				case ast.RestOperator:
+					_rest := e.eval(argValue.Value, env)
+					if _rest.Type() != types.TABLE {
+						panic(errorAt(argValue.Span, "cannot spread %T", _rest))
+					}
+					rest := _rest.(value.Table)
+					for _, key := range rest.Entries.Keys() {
+						entryValue, _ := rest.Entries.Get(key)
+						if key, ok := key.(value.TableKey); ok {
+							if _, ok := function.Contract.Parameters.Get(key); ok {
+								funcEnviron[key.Value] = entryValue
+							} else {
+								panic(errorAt(argValue.Span, "Cannot spread items with names that arent in the parameters (%s)", key.Inspect()))
+							}
+						} else {
+							panic(errorAt(argValue.Span, "Cannot spread items without names"))
+						}
+					}
+				default:
+					if arg.Name == nil {
+						arg.Name = &ast.Identifier{Value: name.Value}
+					}
+					var val value.Value = e.eval(arg.Value, env)
+					if arg.Value == nil {
+						val = param_default
+					}
+					funcEnviron[arg.Name.Value] = val
 
+				}
+			} else {
+				name := &name
+				value := param_default
+				if value == nil {
+					panic(errorAt(node.Span, "no default for %s", name.Value))
+				}
+				funcEnviron[name.Value] = value
 			}
+			i += 1
 		}
-	case ast.RestOperator:
-		panic("rest operator is not a normal expression and cant be used on its own. ")
-	default:
-		panic(fmt.Sprintf("eval error %T", node))
+		if function.Contract.Rest != nil && function.Contract.Parameters.Len() < len(node.Arguments) {
+			rest := value.NewTable()
+			ind := 0
+			for _, arg := range node.Arguments[i:] {
+				switch argValue := arg.Value.(type) {
+				case ast.RestOperator:
+					_rest := e.eval(argValue.Value, env)
+					if _rest.Type() != types.TABLE {
+						panic(errorAt(argValue.Span, "cannot spread %T", _rest))
+					}
+					rest := _rest.(value.Table)
+					for _, key := range rest.Entries.Keys() {
+						entryValue, _ := rest.Entries.Get(key)
+						if key, ok := key.(value.TableKey); ok {
+							if _, ok := function.Contract.Parameters.Get(key); ok {
+								funcEnviron[key.Value] = entryValue
+							} else {
+								panic(errorAt(argValue.Span, "Cannot spread items with names that arent in the parameters"))
+							}
+						} else {
+							panic(errorAt(argValue.Span, "Cannot spread items without names"))
+						}
+					}
+				default:
+					if arg.Name != nil {
+						rest = rest.Set(value.TableKey{Value: arg.Name.Value}, e.eval(arg.Value, env))
+					} else {
+						rest = rest.Set(value.Number{Value: float64(ind)}, e.eval(arg.Value, env))
+						ind += 1
+					}
+				}
+			}
+			funcEnviron[function.Contract.Rest.Value.(ast.Identifier).Value] = rest
+		}
+		return callBuiltin(function, funcEnviron, node.Span)
+
 	}
 	return nil
 }
 
-func getModule(modulePath string) (value.Value, *value.Environment, error) {
-	source, err := os.ReadFile(modulePath)
+// tailCall is a call in tail position, made by the function it is in
+// after that function returns.
+type tailCall struct {
+	body ast.Block
+	env  *value.Environment
+}
+
+func (t *tailCall) Type() string                 { return "" }
+func (t *tailCall) Inspect() string              { return "" }
+func (t *tailCall) Equal(other value.Value) bool { return other == t }
+func (t *tailCall) Hash() uint64                 { return 0 }
+
+// evalFunction evaluates the body of a function. Calls in tail position are made
+// here one after another, so recursion in tail position does not grow the stack.
+func (e *evaluator) evalFunction(body ast.Block, env *value.Environment) value.Value {
+	for {
+		result := e.evalTail(body, env)
+		call, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+		body, env = call.body, call.env
+	}
+}
+
+// evalTail evaluates node in tail position of a function, returning a tailCall
+// for a call of a function there.
+func (e *evaluator) evalTail(node ast.Node, env *value.Environment) value.Value {
+	switch node := node.(type) {
+	case ast.Block:
+		if len(node.Body) == 0 {
+			return nil
+		}
+		last := node.Body[len(node.Body)-1]
+		switch last.(type) {
+		case ast.FunctionCall, ast.CaseExpression:
+		default:
+			return e.eval(node, env)
+		}
+		e.eval(ast.Block{Span: node.Span, Body: node.Body[:len(node.Body)-1]}, env)
+		res := e.evalTail(last, env)
+		if _, ok := res.(*tailCall); !ok {
+			setLast(env, res)
+		}
+		return res
+	case ast.CaseExpression:
+		block, blockEnv := e.selectCase(node, env)
+		return e.evalTail(block, blockEnv)
+	case ast.FunctionCall:
+		return e.call(node, env, true)
+	}
+	return e.eval(node, env)
+}
+
+// lookup returns the value of the name ident is, from where the resolver found it.
+func lookup(ident ast.Identifier, env *value.Environment) (value.Value, bool) {
+	if ident.Slot == ast.Global {
+		return env.Get(ident.Value)
+	}
+	return env.GetAt(ident.Depth, ident.Slot)
+}
+
+// define sets the name ident is in env.
+func define(ident ast.Identifier, env *value.Environment, val value.Value) {
+	if ident.Slot == ast.Global {
+		env.Set(ident.Value, val)
+	} else {
+		env.SetAt(ident.Slot, val)
+	}
+}
+
+// setLast sets _ of env to the value of the last expression statement.
+func setLast(env *value.Environment, val value.Value) {
+	if env.Slots == nil {
+		env.Set("_", val)
+	} else {
+		env.SetAt(0, val)
+	}
+}
+
+// setParameter sets the parameter called name in the scope of a call of function.
+// Parameters are in the slots after _, in order.
+func setParameter(function value.Function, env *value.Environment, name string, val value.Value) {
+	for i, param := range function.Parameters.Keys() {
+		if param.Value == name {
+			env.SetAt(i+1, val)
+			return
+		}
+	}
+}
+
+// callBuiltin calls a builtin function, reporting its failures at the call site.
+func callBuiltin(function value.BuiltinFunction, args map[string]value.Value, span token.Span) value.Value {
+	result, err := function.Fn(args)
 	if err != nil {
-		return nil, nil, fmt.Errorf("no module at %s", modulePath)
+		panic(&RuntimeError{Span: span, Message: err.Error(), Err: err})
 	}
-	m, e := Exec(string(source))
-	return m, e, nil
+	return result
+}
 
+// useModules runs a using statement in the file at file, putting the modules it
+// uses and the symbols it names into env.
+func useModules(loader *Loader, file string, node ast.UsingStatement, env *value.Environment) {
+	for _, module := range node.Modules {
+
+		if builtin, ok := builtinLib.Get(ast.ModuleName(module.Module)); ok {
+			unwrap(module.Module, builtin, env)
+			for _, symbol := range module.Symbols {
+				v, _ := builtin.Entries.Get(value.TableKey{Value: symbol.Value})
+				env.Set(symbol.Value, v)
+			}
+
+		} else {
+			modulePath, err := loader.Find(file, module.Module)
+			if err != nil {
+				panic(errorAt(module.Span, "%s", err.Error()))
+			}
+			moduleEnv, err := loader.load(modulePath)
+			var cycle *ImportCycleError
+			if errors.As(err, &cycle) {
+				panic(&RuntimeError{Span: module.Span, Message: err.Error(), Err: err})
+			} else if err != nil {
+				panic(err)
+			}
+			pubTable := publicToTable(moduleEnv)
+			unwrap(module.Module, pubTable, env)
+			for _, symbol := range module.Symbols {
+				if val, ok := moduleEnv.Get("pub " + symbol.Value); ok {
+					env.Set(symbol.Value, val)
+				}
+			}
+
+		}
+	}
 }
 
 func publicToTable(e *value.Environment) value.Table {
-	table := value.Table{Entries: orderedmap.NewOrderedMap[value.Value, value.Value]()}
+	table := value.NewTable()
 	for key, storeValue := range e.Store {
 		if strings.HasPrefix(key, "pub ") {
-			table.Entries.Set(value.TableKey{Value: strings.SplitAfter(key, "pub ")[1]}, storeValue)
+			table = table.Set(value.TableKey{Value: strings.SplitAfter(key, "pub ")[1]}, storeValue)
 		}
 	}
 	return table
@@ -579,13 +772,8 @@ func getModPath(module ast.Name) string {
 	return ""
 }
 
-func Exec(sourceCode string) (value.Value, *value.Environment) {
-	// the lexer needs to lex indents correctly
-	tokens := token.Tokenize(sourceCode + "\n")
-
-	ast := ast.Parse(&tokens)
-	ast = analyzer.Analyze(ast)
-
-	env := &value.Environment{Store: make(map[string]value.Value), Outer: nil}
-	return Eval(ast, env), env
+// Exec runs source code that is not in a file. The modules it uses are looked for
+// from the working directory.
+func Exec(sourceCode string) (value.Value, *value.Environment, error) {
+	return NewLoader(nil).Exec("", sourceCode)
 }
@@ -0,0 +1,210 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/analyzer"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/diagnostic"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+// ManifestName is the name of the file that marks the root directory of a project.
+const ManifestName = "zygon.mod"
+
+// ImportCycleError is a module using itself, directly or through other modules.
+// Chain lists the paths of the modules in the cycle, starting and ending with the same one.
+type ImportCycleError struct {
+	Chain []string
+}
+
+func (e *ImportCycleError) Error() string {
+	return "import cycle: " + strings.Join(e.Chain, " -> ")
+}
+
+// Loader finds and runs the modules a program uses.
+//
+// A module used by a file is looked for in the directory of that file, then in the
+// root directory of its project and then in the search paths, in order.
+// Every module is run only once, later uses get the same environment.
+// A Loader is not safe for concurrent use.
+type Loader struct {
+	SearchPaths []string
+	// UseVM runs programs by compiling them to bytecode for a virtual machine,
+	// instead of evaluating their syntax trees
+	UseVM bool
+	// modules are the environments of the modules that were run, by canonical path
+	modules map[string]*value.Environment
+	// loading is the chain of modules being run, each one used by the one before it
+	loading []string
+}
+
+// NewLoader makes a Loader that also looks for modules in searchPaths.
+func NewLoader(searchPaths []string) *Loader {
+	return &Loader{SearchPaths: searchPaths, modules: map[string]*value.Environment{}, loading: []string{}}
+}
+
+// SearchPathsFromEnv returns the directories listed in the ZYGON_PATH environment variable.
+func SearchPathsFromEnv() []string {
+	paths := []string{}
+	for _, path := range filepath.SplitList(os.Getenv("ZYGON_PATH")) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Exec runs the source code of the file at filePath, or of no file when filePath is "".
+func (l *Loader) Exec(filePath string, sourceCode string) (value.Value, *value.Environment, error) {
+	if filePath != "" {
+		l.loading = append(l.loading, CanonicalPath(filePath))
+		defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+	}
+
+	// the lexer needs to lex indents correctly
+	tokens, err := token.Tokenize(sourceCode + "\n")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	program, err = analyzer.Analyze(program, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := &value.Environment{Store: make(map[string]value.Value), Outer: nil}
+	result, err := l.Run(filePath, program, env)
+	return result, env, err
+}
+
+// Run evaluates a program from the file at filePath in env, returning the first error it runs into.
+// The program must have been through analyzer.Analyze, which resolves its names.
+func (l *Loader) Run(filePath string, program ast.Program, env *value.Environment) (result value.Value, err error) {
+	defer recoverError(&err)
+	if l.UseVM {
+		vm := &vm{loader: l, file: filePath}
+		return vm.run(compile(program), env), nil
+	}
+	e := &evaluator{loader: l, file: filePath}
+	return e.eval(program, env), nil
+}
+
+// Find returns the path of the module named by module, used by the file at importer.
+func (l *Loader) Find(importer string, module ast.Name) (string, error) {
+	importerDir := "."
+	if importer != "" {
+		importerDir = filepath.Dir(importer)
+	}
+	dirs := []string{importerDir}
+	if root, ok := projectRoot(importerDir); ok {
+		dirs = append(dirs, root)
+	}
+	dirs = append(dirs, l.SearchPaths...)
+
+	relativePath := filepath.FromSlash(strings.TrimPrefix(getModPath(module), "/"))
+	for _, dir := range dirs {
+		modulePath := filepath.Join(dir, relativePath)
+		if info, err := os.Stat(modulePath); err == nil && info.Mode().IsRegular() {
+			return modulePath, nil
+		}
+	}
+	return "", fmt.Errorf("module %s not found, looked in %s", ast.ModuleName(module), strings.Join(dirs, ", "))
+}
+
+// projectRoot returns the closest directory holding a manifest, starting from dir and going up.
+func projectRoot(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ManifestName)); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// load runs the module at modulePath, unless it was run already.
+func (l *Loader) load(modulePath string) (*value.Environment, error) {
+	canonical := CanonicalPath(modulePath)
+	if env, ok := l.modules[canonical]; ok {
+		return env, nil
+	}
+	if cycle := ImportCycle(l.loading, canonical); cycle != nil {
+		return nil, cycle
+	}
+
+	source, err := os.ReadFile(modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("no module at %s: %w", modulePath, err)
+	}
+	_, env, err := l.Exec(modulePath, string(source))
+	if err != nil {
+		// errors in the module point into its own source code
+		var file *diagnostic.File
+		if !errors.As(err, &file) {
+			err = &diagnostic.File{Name: modulePath, Source: string(source), Err: err}
+		}
+		return nil, err
+	}
+	if l.modules == nil {
+		l.modules = map[string]*value.Environment{}
+	}
+	l.modules[canonical] = env
+	return env, nil
+}
+
+// ImportCycle returns the cycle made by using the module at the canonical path while the
+// modules in loading are being loaded, or nil when it does not make one.
+func ImportCycle(loading []string, canonical string) *ImportCycleError {
+	for i, path := range loading {
+		if path == canonical {
+			chain := []string{}
+			for _, path := range loading[i:] {
+				chain = append(chain, displayPath(path))
+			}
+			chain = append(chain, displayPath(canonical))
+			return &ImportCycleError{Chain: chain}
+		}
+	}
+	return nil
+}
+
+// CanonicalPath returns the absolute path of a file with symbolic links resolved,
+// so that every path to a module leads to the same one.
+func CanonicalPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+// displayPath shortens a path to be relative to the working directory when it is inside it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,471 @@
+package evaluator
+
+import (
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/types"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+// closure is a compiled function together with the scope it was declared in.
+type closure struct {
+	fn *function
+	// defaults are the default values of the parameters, nil when one has none
+	defaults []value.Value
+	env      *value.Environment
+}
+
+func (c *closure) Type() string                 { return types.FUNCTION }
+func (c *closure) Inspect() string              { return "Function Declaration" }
+func (c *closure) Equal(other value.Value) bool { return other == c }
+func (c *closure) Hash() uint64                 { return value.FunctionHash(c) }
+
+// vm runs compiled code of one module.
+type vm struct {
+	loader *Loader
+	// file is the path of the module, or "" when the code is not from a file
+	file  string
+	stack []value.Value
+}
+
+func (vm *vm) push(val value.Value) {
+	vm.stack = append(vm.stack, val)
+}
+
+func (vm *vm) pop() value.Value {
+	val := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return val
+}
+
+func (vm *vm) popN(n int) []value.Value {
+	values := make([]value.Value, n)
+	copy(values, vm.stack[len(vm.stack)-n:])
+	vm.stack = vm.stack[:len(vm.stack)-n]
+	return values
+}
+
+// run runs c in env and returns the value it leaves.
+func (vm *vm) run(c *chunk, env *value.Environment) value.Value {
+	base := len(vm.stack)
+	code := c.code
+	for ip := 0; ip < len(code); {
+		op := opcode(code[ip])
+		operand := 0
+		if opDefinitions[op].operands > 0 {
+			operand = readOperand(code, ip+1)
+		}
+		ip += 1 + operandWidth*opDefinitions[op].operands
+
+		switch op {
+		case opConstant:
+			vm.push(c.constants[operand].(value.Value))
+		case opNil:
+			vm.push(nil)
+		case opPop:
+			vm.pop()
+		case opDup:
+			vm.push(vm.stack[len(vm.stack)-1])
+		case opPopUnder:
+			top := vm.pop()
+			vm.stack[len(vm.stack)-1] = top
+
+		case opGetName:
+			ident := c.constants[operand].(ast.Identifier)
+			val, ok := lookup(ident, env)
+			if !ok {
+				panic(errorAt(ident.Span, "\"%s\" is not defined", ident.Value))
+			}
+			vm.push(val)
+		case opDefine:
+			define(c.constants[operand].(ast.Identifier), env, vm.pop())
+		case opSetLast:
+			setLast(env, vm.pop())
+		case opCheckUnbound:
+			ident := c.constants[operand].(ast.Identifier)
+			if _, ok := lookup(ident, env); ok {
+				panic(errorAt(ident.Span, "Cannot reassign identifier %s", ident.Value))
+			}
+		case opCheckValue:
+			if vm.stack[len(vm.stack)-1] == nil {
+				panic(errorAt(c.constants[operand].(token.Span), "value does not produce anything"))
+			}
+		case opPublish:
+			name := c.constants[operand].(string)
+			env.Set("pub "+name, env.Store[name])
+
+		case opNegate:
+			switch right := vm.pop().(type) {
+			case value.Number:
+				vm.push(value.Number{Value: -right.Value})
+			default:
+				panic(errorAt(c.constants[operand].(token.Span), "non number passed to -"))
+			}
+		case opNot:
+			switch right := vm.pop().(type) {
+			case value.Boolean:
+				vm.push(value.Boolean{Value: !right.Value})
+			default:
+				panic(errorAt(c.constants[operand].(token.Span), "non boolean passed to not"))
+			}
+		case opAdd, opSub, opMul, opDiv, opGreater, opLess:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(arithmetic(op, left, right, c.constants[operand].(token.Span)))
+		case opEqual:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(value.Boolean{Value: value.Equal(left, right)})
+		case opNotEqual:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(value.Boolean{Value: !value.Equal(left, right)})
+		case opCheckBool:
+			if _, ok := vm.stack[len(vm.stack)-1].(value.Boolean); !ok {
+				check := c.constants[operand].(*boolCheck)
+				panic(errorAt(check.span, "%s", check.message))
+			}
+		case opAnd:
+			right := vm.pop().(value.Boolean)
+			left := vm.pop().(value.Boolean)
+			vm.push(value.Boolean{Value: left.Value && right.Value})
+
+		case opJump:
+			ip = operand
+		case opJumpIfFalse:
+			if !vm.pop().(value.Boolean).Value {
+				ip = operand
+			}
+		case opJumpIfTrue:
+			if vm.pop().(value.Boolean).Value {
+				ip = operand
+			}
+
+		case opText:
+			str := ""
+			for _, part := range vm.popN(operand) {
+				str = str + part.Inspect()
+			}
+			vm.push(value.Text{Value: str})
+		case opTable:
+			desc := c.constants[operand].(*tableDesc)
+			vm.push(makeTable(desc, vm.popN(desc.values)))
+		case opIndex:
+			desc := c.constants[operand].(*indexDesc)
+			var index value.Value = value.TableKey{Value: desc.key}
+			if desc.computed {
+				index = vm.pop()
+			}
+			vm.push(indexTable(desc, vm.pop(), index))
+		case opClosure:
+			fn := c.constants[operand].(*function)
+			numDefaults := 0
+			for _, hasDefault := range fn.defaults {
+				if hasDefault {
+					numDefaults += 1
+				}
+			}
+			values := vm.popN(numDefaults)
+			defaults := make([]value.Value, len(fn.params))
+			for i, hasDefault := range fn.defaults {
+				if hasDefault {
+					defaults[i] = values[0]
+					values = values[1:]
+				}
+			}
+			vm.push(&closure{fn: fn, defaults: defaults, env: env})
+		case opCall:
+			site := c.constants[operand].(*callSite)
+			args := vm.popN(len(site.args))
+			vm.push(vm.call(vm.pop(), args, site))
+		case opTailCall:
+			site := c.constants[operand].(*callSite)
+			args := vm.popN(len(site.args))
+			fn := vm.pop()
+			if function, ok := fn.(*closure); ok {
+				// the called function takes the place of this one
+				env = vm.enter(function, args, site)
+				c, code = function.fn.body, function.fn.body.code
+				vm.stack = vm.stack[:base]
+				ip = 0
+				break
+			}
+			vm.push(vm.call(fn, args, site))
+
+		case opCheckPattern:
+			val := vm.stack[len(vm.stack)-1]
+			if val == nil {
+				panic(errorAt(c.constants[operand].(token.Span), "pattern does not eval to anything"))
+			}
+			if val.Type() != types.BOOL {
+				panic(errorAt(c.constants[operand].(token.Span), "pattern result is not a boolean"))
+			}
+		case opCheckGuard:
+			val := vm.stack[len(vm.stack)-1]
+			if val == nil {
+				panic(errorAt(c.constants[operand].(token.Span), "guard does not eval to anything"))
+			}
+			if val.Type() != types.BOOL {
+				panic(errorAt(c.constants[operand].(token.Span), "guard result is not a boolean"))
+			}
+		case opMatch:
+			desc := c.constants[operand].(*matchDesc)
+			arm := env
+			if desc.arm {
+				arm = &value.Environment{Slots: make([]value.Value, desc.numSlots), Outer: env}
+			}
+			if !vm.match(desc.pattern, vm.stack[len(vm.stack)-1], env, arm) {
+				ip = readOperand(code, ip-operandWidth)
+				break
+			}
+			env = arm
+		case opEnterScope:
+			env = &value.Environment{Slots: make([]value.Value, operand), Outer: env}
+		case opLeaveScope:
+			env = env.Outer
+
+		case opUsing:
+			useModules(vm.loader, vm.file, c.constants[operand].(ast.UsingStatement), env)
+		case opError:
+			panic(c.constants[operand].(*RuntimeError))
+		case opReturn:
+			result := vm.pop()
+			vm.stack = vm.stack[:base]
+			return result
+		}
+	}
+	return nil
+}
+
+// arithmetic runs an operation on two numbers, the operator of which is at span.
+func arithmetic(op opcode, left value.Value, right value.Value, span token.Span) value.Value {
+	if left == nil || right == nil || left.Type() != types.NUMBER || right.Type() != types.NUMBER {
+		panic(errorAt(span, "expected two numbers, got %s and %s", value.TypeName(left), value.TypeName(right)))
+	}
+	l, r := left.(value.Number).Value, right.(value.Number).Value
+	switch op {
+	case opAdd:
+		return value.Number{Value: l + r}
+	case opSub:
+		return value.Number{Value: l - r}
+	case opMul:
+		return value.Number{Value: l * r}
+	case opDiv:
+		return value.Number{Value: l / r}
+	case opGreater:
+		return value.Boolean{Value: l > r}
+	case opLess:
+		return value.Boolean{Value: l < r}
+	}
+	return nil
+}
+
+func makeTable(desc *tableDesc, values []value.Value) value.Table {
+	entries := value.NewTable()
+	index := -1
+	for _, entry := range desc.entries {
+		val := values[0]
+		values = values[1:]
+		switch entry.kind {
+		case computedEntry:
+			if val == nil {
+				panic(errorAt(entry.span, "table keys cannot be nothing"))
+			}
+			entries = entries.Set(val, values[0])
+			values = values[1:]
+		case namedEntry:
+			entries = entries.Set(value.TableKey{Value: entry.key}, val)
+		case spreadEntry:
+			if val.Type() != types.TABLE {
+				panic(errorAt(entry.span, "cannot spread non table values"))
+			}
+			table := val.(value.Table)
+			entries, index = table.SpreadInto(entries, index)
+		default:
+			index += 1
+			entries = entries.Set(value.Number{Value: float64(index)}, val)
+		}
+	}
+	return entries
+}
+
+func indexTable(desc *indexDesc, subject value.Value, index value.Value) value.Value {
+	switch subject := subject.(type) {
+	case value.Table:
+		val, ok := subject.Entries.Get(index)
+		if !ok {
+			panic(errorAt(desc.attributeSpan, "%s does not have the \"%s\" attribute", desc.subjectName, index.Inspect()))
+		}
+		return val
+	default:
+		panic(errorAt(desc.subjectSpan, "Cannot index type %T", subject))
+	}
+}
+
+// call calls fn with the values of the arguments at site.
+func (vm *vm) call(fn value.Value, args []value.Value, site *callSite) value.Value {
+	switch function := fn.(type) {
+	case *closure:
+		return vm.run(function.fn.body, vm.enter(function, args, site))
+	case value.BuiltinFunction:
+		params := []string{}
+		defaults := []value.Value{}
+		for _, name := range function.Contract.Parameters.Keys() {
+			paramDefault, _ := function.Contract.Parameters.Get(name)
+			params = append(params, name.Value)
+			defaults = append(defaults, paramDefault)
+		}
+		isParam := func(name string) bool {
+			_, ok := function.Contract.Parameters.Get(value.TableKey{Value: name})
+			return ok
+		}
+		funcEnviron := map[string]value.Value{}
+		set := func(name string, val value.Value) { funcEnviron[name] = val }
+		rest := bindArguments(params, defaults, isParam, function.Contract.Rest != nil, args, site, set)
+		if rest != nil {
+			funcEnviron[function.Contract.Rest.Value.(ast.Identifier).Value] = rest
+		}
+		return callBuiltin(function, funcEnviron, site.span)
+	}
+	return nil
+}
+
+// enter makes the scope of a call of function, with its parameters set to the arguments.
+func (vm *vm) enter(function *closure, args []value.Value, site *callSite) *value.Environment {
+	env := &value.Environment{Slots: make([]value.Value, function.fn.numSlots), Outer: function.env}
+	set := func(name string, val value.Value) {
+		for i, param := range function.fn.params {
+			if param == name {
+				env.SetAt(i+1, val)
+				return
+			}
+		}
+	}
+	isParam := func(name string) bool {
+		for _, param := range function.fn.params {
+			if param == name {
+				return true
+			}
+		}
+		return false
+	}
+	rest := bindArguments(function.fn.params, function.defaults, isParam, function.fn.restSlot != -1, args, site, set)
+	if rest != nil {
+		env.SetAt(function.fn.restSlot, rest)
+	}
+	return env
+}
+
+// bindArguments sets the parameters of a function to the arguments of a call and
+// returns the table of the arguments left for its rest parameter, if it gets one.
+func bindArguments(params []string, defaults []value.Value, isParam func(string) bool, hasRest bool, args []value.Value, site *callSite, set func(string, value.Value)) value.Value {
+	for i, param := range params {
+		if i >= len(args) {
+			if defaults[i] == nil {
+				panic(errorAt(site.span, "no default for %s", param))
+			}
+			set(param, defaults[i])
+			continue
+		}
+		arg := site.args[i]
+		switch {
+		case arg.spread:
+			spreadArgument(args[i], arg.span, isParam, set, true)
+		case arg.name != "":
+			set(arg.name, args[i])
+		default:
+			set(param, args[i])
+		}
+	}
+	if !hasRest || len(params) >= len(args) {
+		return nil
+	}
+
+	rest := value.NewTable()
+	ind := 0
+	for i := len(params); i < len(args); i++ {
+		arg := site.args[i]
+		switch {
+		case arg.spread:
+			spreadArgument(args[i], arg.span, isParam, set, false)
+		case arg.name != "":
+			rest = rest.Set(value.TableKey{Value: arg.name}, args[i])
+		default:
+			rest = rest.Set(value.Number{Value: float64(ind)}, args[i])
+			ind += 1
+		}
+	}
+	return rest
+}
+
+// spreadArgument sets the parameters named by the keys of a spread table.
+func spreadArgument(arg value.Value, span token.Span, isParam func(string) bool, set func(string, value.Value), nameInError bool) {
+	if arg.Type() != types.TABLE {
+		panic(errorAt(span, "cannot spread %T", arg))
+	}
+	rest := arg.(value.Table)
+	for _, key := range rest.Entries.Keys() {
+		entryValue, _ := rest.Entries.Get(key)
+		name, ok := key.(value.TableKey)
+		if !ok {
+			panic(errorAt(span, "Cannot spread items without names"))
+		}
+		if !isParam(name.Value) {
+			if nameInError {
+				panic(errorAt(span, "Cannot spread items with names that arent in the parameters (%s)", key.Inspect()))
+			}
+			panic(errorAt(span, "Cannot spread items with names that arent in the parameters"))
+		}
+		set(name.Value, entryValue)
+	}
+}
+
+// match tells whether val matches a compiled pattern the way the evaluator does, setting
+// the names the pattern binds in arm. Literals are run in env, where the pattern is.
+func (vm *vm) match(p *pattern, val value.Value, env *value.Environment, arm *value.Environment) bool {
+	switch p.kind {
+	case literalPattern:
+		return value.Equal(val, vm.run(p.literal, env))
+	case bindPattern:
+		arm.SetAt(p.slot, val)
+		return true
+	case wildcardPattern:
+		return true
+	case typePattern:
+		typ, ok := vm.run(p.literal, env).(value.Type)
+		if !ok {
+			panic(errorAt(p.span, "type pattern does not eval to a type"))
+		}
+		if valType, ok := value.TypeOf(val); !ok || valType != typ {
+			return false
+		}
+		if p.slot != -1 {
+			arm.SetAt(p.slot, val)
+		}
+		return true
+	case alternativePattern:
+		for _, alternative := range p.alternatives {
+			if vm.match(alternative, val, env, arm) {
+				return true
+			}
+		}
+		return false
+	}
+	table, ok := val.(value.Table)
+	if !ok {
+		return false
+	}
+	for _, entry := range p.entries {
+		entryValue, ok := table.Entries.Get(entry.key)
+		if !ok || !vm.match(entry.value, entryValue, env, arm) {
+			return false
+		}
+	}
+	if !p.rest {
+		return table.Entries.Len() == len(p.entries)
+	}
+	if p.slot != -1 {
+		arm.SetAt(p.slot, table.Rest(p.keys, p.positional))
+	}
+	return true
+}
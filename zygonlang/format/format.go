@@ -0,0 +1,251 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/token"
+)
+
+const indentation = "    "
+
+// Source formats zygon source code by the style rules of the language.
+// The code has to parse, so that formatting never changes what it means.
+// Comments are kept, runs of empty lines are squashed into one and
+// code inside parentheses is put on one line.
+func Source(sourceCode string) (string, error) {
+	tokens, err := token.Tokenize(sourceCode + "\n")
+	if err != nil {
+		return "", err
+	}
+	if _, err := ast.Parse(&tokens); err != nil {
+		return "", err
+	}
+
+	lexer := token.NewLexer(sourceCode + "\n")
+	lexer.KeepComments = true
+	tokens, err = lexer.Tokenize()
+	if err != nil {
+		return "", err
+	}
+
+	f := &formatter{source: []byte(sourceCode + "\n"), tokens: tokens.Contents, widths: []int{0}}
+	f.format()
+	return f.String(), nil
+}
+
+type pendingLine struct {
+	text  string
+	width int
+	depth int
+}
+
+type formatter struct {
+	source []byte
+	tokens []token.Token
+	index  int
+
+	lines []string
+	line  strings.Builder
+	depth int
+	// widths are the indentations in the source of every block the current line is in
+	widths []int
+	// pending are the empty and comment lines since the last line of code, whose depth
+	// depends on the line of code after them
+	pending []pendingLine
+	// prev is the last token written on the current line
+	prev *token.Token
+	// beforePrev is the token before prev, used to tell a minus from a negation
+	beforePrev *token.Token
+}
+
+func (f *formatter) format() {
+	lineDepth := 0
+	for f.index < len(f.tokens) {
+		tok := f.tokens[f.index]
+		switch tok.Type {
+		case token.EOF:
+			f.endLine(lineDepth)
+			f.flushPending(-1)
+			f.index += 1
+		case token.EOL:
+			f.endLine(lineDepth)
+			lineDepth = f.depth
+			f.index += 1
+		case token.INDENT:
+			f.depth += 1
+			width, _ := strconv.Atoi(tok.Value)
+			f.widths = append(f.widths, width)
+			lineDepth = f.depth
+			f.index += 1
+		case token.DEDENT:
+			f.depth -= 1
+			f.widths = f.widths[:len(f.widths)-1]
+			lineDepth = f.depth
+			f.index += 1
+		case token.COMMENT:
+			if f.prev == nil && f.tokens[f.index+1].Type == token.EOL {
+				// a line with only a comment
+				prefix := string(f.source[tok.Span.Start.Offset-(tok.Span.Start.Column-1) : tok.Span.Start.Offset])
+				width := len(strings.ReplaceAll(prefix, "\t", "    "))
+				text := strings.TrimRight(tok.Value, " \t\r")
+				f.pending = append(f.pending, pendingLine{text: text, width: width, depth: f.depthOf(width)})
+				f.index += 2
+				continue
+			}
+			f.write(tok, strings.TrimRight(tok.Value, " \t\r"))
+			f.index += 1
+			// a comment inside parentheses is not followed by an EOL, so the line is broken here
+			if next := f.tokens[f.index]; next.Type != token.EOL && next.Type != token.EOF {
+				f.endLine(lineDepth)
+				lineDepth = f.depth + 1
+			}
+		case token.TEXT_START:
+			f.write(tok, f.text())
+		default:
+			f.write(tok, string(f.source[tok.Span.Start.Offset:tok.Span.End.Offset]))
+			f.index += 1
+		}
+	}
+}
+
+// text formats a text literal starting at the current TEXT_START token, together
+// with the code interpolated into it, and moves past its TEXT_END token.
+func (f *formatter) text() string {
+	var out strings.Builder
+	out.WriteString("\"")
+	f.index += 1
+	for {
+		tok := f.tokens[f.index]
+		switch tok.Type {
+		case token.TEXT_END:
+			f.index += 1
+			out.WriteString("\"")
+			return out.String()
+		case token.TEXT_PART:
+			out.WriteString(escape(tok.Value))
+			f.index += 1
+			if f.tokens[f.index].Type == token.TEXT_END {
+				continue
+			}
+			// everything up to the next part of this text is interpolated
+			inner := &formatter{source: f.source, tokens: f.tokens, index: f.index}
+			for inner.tokens[inner.index].Type != token.TEXT_PART {
+				if inner.tokens[inner.index].Type == token.TEXT_START {
+					inner.write(inner.tokens[inner.index], inner.text())
+				} else {
+					tok := inner.tokens[inner.index]
+					inner.write(tok, string(f.source[tok.Span.Start.Offset:tok.Span.End.Offset]))
+					inner.index += 1
+				}
+			}
+			out.WriteString("{" + inner.line.String() + "}")
+			f.index = inner.index
+		}
+	}
+}
+
+func escape(text string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "{", "\\{", "}", "\\}")
+	return replacer.Replace(text)
+}
+
+func (f *formatter) write(tok token.Token, text string) {
+	if f.prev != nil && f.spaceBetween(*f.prev, tok) {
+		f.line.WriteString(" ")
+	}
+	f.line.WriteString(text)
+	f.beforePrev = f.prev
+	f.prev = &tok
+}
+
+func (f *formatter) endLine(depth int) {
+	line := f.line.String()
+	f.line.Reset()
+	f.prev, f.beforePrev = nil, nil
+	if line == "" {
+		f.pending = append(f.pending, pendingLine{text: "", width: -1})
+		return
+	}
+	f.flushPending(depth)
+	f.lines = append(f.lines, strings.Repeat(indentation, max(depth, 0))+line)
+}
+
+// flushPending writes the pending lines before a line of code at depth, or at the end
+// of the file when depth is -1. A comment goes to the deepest block its indentation
+// fits in, either the one before it or the one after it.
+func (f *formatter) flushPending(depth int) {
+	for _, pending := range f.pending {
+		if pending.text == "" {
+			// only one empty line in a row, and none at the start
+			if len(f.lines) > 0 && f.lines[len(f.lines)-1] != "" {
+				f.lines = append(f.lines, "")
+			}
+			continue
+		}
+		commentDepth := pending.depth
+		if depth >= 0 {
+			commentDepth = max(commentDepth, min(f.depthOf(pending.width), depth))
+		}
+		f.lines = append(f.lines, strings.Repeat(indentation, commentDepth)+pending.text)
+	}
+	f.pending = nil
+}
+
+// depthOf returns the deepest block of the current line that starts at most width columns in.
+func (f *formatter) depthOf(width int) int {
+	depth := 0
+	for i, blockWidth := range f.widths {
+		if blockWidth <= width {
+			depth = i
+		}
+	}
+	return depth
+}
+
+func (f *formatter) String() string {
+	lines := f.lines
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// spaceBetween reports whether a space goes between two tokens on the same line.
+func (f *formatter) spaceBetween(prev token.Token, cur token.Token) bool {
+	switch cur.Type {
+	case token.RPAREN, token.RBRACE, token.COMMA, token.COLON:
+		return false
+	case token.DOT:
+		// the key of a computed entry of a table comes after a comma
+		return prev.Type == token.COMMA
+	case token.LPAREN:
+		// calls and declarations
+		switch prev.Type {
+		case token.IDENT, token.RPAREN, token.RBRACE, token.TEXT_END, token.DOT:
+			return false
+		}
+	}
+	switch prev.Type {
+	case token.LPAREN, token.LBRACE, token.DOT, token.REST:
+		return false
+	case token.MINUS:
+		return !f.isNegation()
+	}
+	return true
+}
+
+// isNegation reports whether the last minus written is a prefix minus.
+func (f *formatter) isNegation() bool {
+	if f.beforePrev == nil {
+		return true
+	}
+	switch f.beforePrev.Type {
+	case token.IDENT, token.NUM, token.RPAREN, token.RBRACE, token.TEXT_END, token.TRUE, token.FALSE:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,323 @@
+package native
+
+import (
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/types"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+// Function is a compiled function declaration. Body gets the values of the parameters
+// in order, followed by the table of the rest parameter. A parameter no argument was
+// given to is Unset, like the rest parameter when there are no arguments left for it.
+type Function struct {
+	Name   string
+	Params []string
+	// Defaults are the default values of the parameters, nil when one has none
+	Defaults []value.Value
+	Rest     bool
+	Body     func(args []value.Value) value.Value
+}
+
+func (f *Function) Type() string                 { return types.FUNCTION }
+func (f *Function) Inspect() string              { return "Function Declaration" }
+func (f *Function) Equal(other value.Value) bool { return other == f }
+func (f *Function) Hash() uint64                 { return value.FunctionHash(f) }
+
+func (f *Function) param(name string) int {
+	for i, param := range f.Params {
+		if param == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Argument is an argument of a call. It is passed by position unless it has a name,
+// spread arguments pass the entries of a table by their names.
+type Argument struct {
+	Name   string
+	Spread bool
+	Pos    Pos
+}
+
+// CallSite is a function call in the source code.
+type CallSite struct {
+	Pos  Pos
+	Args []Argument
+}
+
+// tailCall is a call in tail position, made by the call of the function it is in
+// after that function returns.
+type tailCall struct {
+	site *CallSite
+	fn   *Function
+	args []value.Value
+}
+
+func (t *tailCall) Type() string                 { return "" }
+func (t *tailCall) Inspect() string              { return "" }
+func (t *tailCall) Equal(other value.Value) bool { return other == t }
+func (t *tailCall) Hash() uint64                 { return 0 }
+
+// Call calls fn with the arguments of site. Calling something that is not a function gives nil.
+func Call(site *CallSite, fn value.Value, args ...value.Value) value.Value {
+	for {
+		switch function := fn.(type) {
+		case *Function:
+			result := function.Body(function.bind(site, args))
+			call, ok := result.(*tailCall)
+			if !ok {
+				return result
+			}
+			site, fn, args = call.site, call.fn, call.args
+		case value.BuiltinFunction:
+			return callBuiltin(function, site, args)
+		default:
+			return nil
+		}
+	}
+}
+
+// TailCall calls fn in tail position. A compiled function is not called here,
+// the call of the function this one is in makes the call once it returns.
+func TailCall(site *CallSite, fn value.Value, args ...value.Value) value.Value {
+	if function, ok := fn.(*Function); ok {
+		return &tailCall{site: site, fn: function, args: args}
+	}
+	return Call(site, fn, args...)
+}
+
+// bind returns the values of the parameters of f for a call with args.
+func (f *Function) bind(site *CallSite, args []value.Value) []value.Value {
+	params := make([]value.Value, len(f.Params)+1)
+	for i := range params {
+		params[i] = Unset
+	}
+	defaults := f.Defaults
+	if defaults == nil {
+		defaults = make([]value.Value, len(f.Params))
+	}
+	isParam := func(name string) bool { return f.param(name) != -1 }
+	set := func(name string, val value.Value) {
+		if i := f.param(name); i != -1 {
+			params[i] = val
+		}
+	}
+	if rest := bindArguments(f.Params, defaults, isParam, f.Rest, site, args, set); rest != nil {
+		params[len(f.Params)] = rest
+	}
+	return params
+}
+
+func callBuiltin(function value.BuiltinFunction, site *CallSite, args []value.Value) value.Value {
+	params := []string{}
+	defaults := []value.Value{}
+	for _, name := range function.Contract.Parameters.Keys() {
+		paramDefault, _ := function.Contract.Parameters.Get(name)
+		params = append(params, name.Value)
+		defaults = append(defaults, paramDefault)
+	}
+	isParam := func(name string) bool {
+		_, ok := function.Contract.Parameters.Get(value.TableKey{Value: name})
+		return ok
+	}
+	funcEnviron := map[string]value.Value{}
+	set := func(name string, val value.Value) { funcEnviron[name] = val }
+	if rest := bindArguments(params, defaults, isParam, function.Contract.Rest != nil, site, args, set); rest != nil {
+		funcEnviron[function.Contract.Rest.Value.(ast.Identifier).Value] = rest
+	}
+	result, err := function.Fn(funcEnviron)
+	if err != nil {
+		panic(&Error{Pos: site.Pos, Message: err.Error(), Err: err})
+	}
+	return result
+}
+
+// bindArguments sets the parameters of a function to the arguments of a call and
+// returns the table of the arguments left for its rest parameter, if it gets one.
+func bindArguments(params []string, defaults []value.Value, isParam func(string) bool, hasRest bool, site *CallSite, args []value.Value, set func(string, value.Value)) value.Value {
+	for i, param := range params {
+		if i >= len(args) {
+			if defaults[i] == nil {
+				panic(errorAt(site.Pos, "no default for %s", param))
+			}
+			set(param, defaults[i])
+			continue
+		}
+		arg := site.Args[i]
+		switch {
+		case arg.Spread:
+			spreadArgument(args[i], arg.Pos, isParam, set, true)
+		case arg.Name != "":
+			set(arg.Name, args[i])
+		default:
+			set(param, args[i])
+		}
+	}
+	if !hasRest || len(params) >= len(args) {
+		return nil
+	}
+
+	rest := value.NewTable()
+	ind := 0
+	for i := len(params); i < len(args); i++ {
+		arg := site.Args[i]
+		switch {
+		case arg.Spread:
+			spreadArgument(args[i], arg.Pos, isParam, set, false)
+		case arg.Name != "":
+			rest = rest.Set(value.TableKey{Value: arg.Name}, args[i])
+		default:
+			rest = rest.Set(value.Number{Value: float64(ind)}, args[i])
+			ind += 1
+		}
+	}
+	return rest
+}
+
+// spreadArgument sets the parameters named by the keys of a spread table.
+func spreadArgument(arg value.Value, pos Pos, isParam func(string) bool, set func(string, value.Value), nameInError bool) {
+	if arg.Type() != types.TABLE {
+		panic(errorAt(pos, "cannot spread %T", arg))
+	}
+	rest := arg.(value.Table)
+	for _, key := range rest.Entries.Keys() {
+		entryValue, _ := rest.Entries.Get(key)
+		name, ok := key.(value.TableKey)
+		if !ok {
+			panic(errorAt(pos, "Cannot spread items without names"))
+		}
+		if !isParam(name.Value) {
+			if nameInError {
+				panic(errorAt(pos, "Cannot spread items with names that arent in the parameters (%s)", key.Inspect()))
+			}
+			panic(errorAt(pos, "Cannot spread items with names that arent in the parameters"))
+		}
+		set(name.Value, entryValue)
+	}
+}
+
+// CheckPattern returns the result of a pattern of a case without a subject,
+// which has to be a boolean.
+func CheckPattern(result value.Value, pos Pos) bool {
+	if result == nil {
+		panic(errorAt(pos, "pattern does not eval to anything"))
+	}
+	if result.Type() != types.BOOL {
+		panic(errorAt(pos, "pattern result is not a boolean"))
+	}
+	return IsTrue(result)
+}
+
+// CheckGuard returns the result of the guard of an arm of a case, which has to be a boolean.
+func CheckGuard(result value.Value, pos Pos) bool {
+	if result == nil {
+		panic(errorAt(pos, "guard does not eval to anything"))
+	}
+	if result.Type() != types.BOOL {
+		panic(errorAt(pos, "guard result is not a boolean"))
+	}
+	return IsTrue(result)
+}
+
+type PatternKind int
+
+const (
+	// LiteralPattern matches a value equal to that of a literal
+	LiteralPattern PatternKind = iota
+	// BindPattern binds the value to a name
+	BindPattern
+	// WildcardPattern matches any value, it is written _
+	WildcardPattern
+	// TablePattern matches a table whose entries match its own
+	TablePattern
+	// AlternativePattern matches a value one of its alternatives matches
+	AlternativePattern
+	// TypePattern matches a value of a type, and binds it to a name
+	TypePattern
+)
+
+// Pattern is the pattern of an arm of a case with a subject, or of a line of a chain.
+type Pattern struct {
+	Kind PatternKind
+	// Literal is the index of the value of a literal pattern, or of the type of a type pattern
+	Literal int
+	// Bind is the index of the name a bind or type pattern, or the rest of a table pattern,
+	// binds. It is -1 for a type pattern or a rest without a name.
+	Bind    int
+	Entries []PatternEntry
+	Rest    bool
+	// Alternatives are the patterns of an alternative pattern
+	Alternatives []*Pattern
+	// Pos is where the type of a type pattern is
+	Pos Pos
+}
+
+type PatternEntry struct {
+	// Key is a value.TableKey for a named entry, and the position of the others
+	Key   value.Value
+	Value *Pattern
+}
+
+// Match tells whether subject matches a pattern, and returns the values of the names the
+// pattern binds by their index. literals give the values of the literal patterns, they are
+// only called when the pattern is matched.
+func Match(pattern *Pattern, binds int, subject value.Value, literals ...func() value.Value) ([]value.Value, bool) {
+	values := make([]value.Value, binds)
+	return values, match(pattern, subject, values, literals)
+}
+
+func match(pattern *Pattern, subject value.Value, binds []value.Value, literals []func() value.Value) bool {
+	switch pattern.Kind {
+	case LiteralPattern:
+		return value.Equal(subject, literals[pattern.Literal]())
+	case BindPattern:
+		binds[pattern.Bind] = subject
+		return true
+	case WildcardPattern:
+		return true
+	case TypePattern:
+		typ, ok := literals[pattern.Literal]().(value.Type)
+		if !ok {
+			panic(errorAt(pattern.Pos, "type pattern does not eval to a type"))
+		}
+		if subjectType, ok := value.TypeOf(subject); !ok || subjectType != typ {
+			return false
+		}
+		if pattern.Bind != -1 {
+			binds[pattern.Bind] = subject
+		}
+		return true
+	case AlternativePattern:
+		for _, alternative := range pattern.Alternatives {
+			if match(alternative, subject, binds, literals) {
+				return true
+			}
+		}
+		return false
+	}
+	table, ok := subject.(value.Table)
+	if !ok {
+		return false
+	}
+	keys := []value.Value{}
+	positional := 0
+	for _, entry := range pattern.Entries {
+		if entry.Key.Type() == types.NUMBER {
+			positional += 1
+		} else {
+			keys = append(keys, entry.Key)
+		}
+		val, ok := table.Entries.Get(entry.Key)
+		if !ok || !match(entry.Value, val, binds, literals) {
+			return false
+		}
+	}
+	if !pattern.Rest {
+		return table.Entries.Len() == len(pattern.Entries)
+	}
+	if pattern.Bind != -1 {
+		binds[pattern.Bind] = table.Rest(keys, positional)
+	}
+	return true
+}
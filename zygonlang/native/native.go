@@ -0,0 +1,330 @@
+// Package native is the runtime of programs compiled to Go by zygon build.
+// The generated code calls into it for everything that is not plain control flow,
+// so a compiled program behaves like the same program run by zygon run.
+package native
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/builtin"
+	"thechosenzendro/zygonlang/zygonlang/diagnostic"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/types"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+var builtinLib = builtin.BuiltinLib()
+
+// Module is a zygon file compiled into the program. Its source code is kept
+// to show errors the way zygon run shows them.
+type Module struct {
+	Name   string
+	Source string
+	// env is the global scope of the module, once it was run
+	env *value.Environment
+}
+
+// Pos is a part of the source code of a module.
+type Pos struct {
+	Module *Module
+	Span   token.Span
+}
+
+// At returns the part of the source code of m between two positions.
+func (m *Module) At(startLine int, startColumn int, endLine int, endColumn int) Pos {
+	return Pos{Module: m, Span: token.Span{
+		Start: token.Position{Line: startLine, Column: startColumn},
+		End:   token.Position{Line: endLine, Column: endColumn},
+	}}
+}
+
+// Error is a problem that happened while running the program.
+// Err is set when the problem came from a builtin function.
+type Error struct {
+	Pos
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Span.Start.Line, e.Span.Start.Column, e.Message)
+}
+func (e *Error) Range() token.Span { return e.Span }
+func (e *Error) Msg() string       { return e.Message }
+func (e *Error) Unwrap() error     { return e.Err }
+
+func errorAt(pos Pos, format string, args ...any) *Error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// Fail stops the program with an error at pos.
+func Fail(pos Pos, message string) {
+	panic(errorAt(pos, "%s", message))
+}
+
+// Main runs the main module of a program, printing its result or the error it stopped with.
+func Main(main *Module, run func(env *value.Environment) value.Value) {
+	builtin.Args = os.Args[1:]
+	result, err := start(main, run)
+	if err != nil {
+		var crash *builtin.Crash
+		if errors.As(err, &crash) {
+			fmt.Println(crash.Error())
+			os.Exit(crash.ExitCode)
+		}
+		name, source := main.Name, main.Source
+		var runErr *Error
+		if errors.As(err, &runErr) {
+			name, source = runErr.Module.Name, runErr.Module.Source
+		}
+		fmt.Fprint(os.Stderr, diagnostic.Render(name, source, err))
+		os.Exit(1)
+	}
+	if result != nil {
+		fmt.Println(result.Inspect())
+	}
+}
+
+// start runs a module, returning the first error it runs into.
+// Go runtime errors are bugs in the program, so they are not stopped.
+func start(module *Module, run func(env *value.Environment) value.Value) (result value.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			runErr, ok := r.(error)
+			if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+				panic(r)
+			}
+			err = runErr
+		}
+	}()
+	module.env = &value.Environment{Store: map[string]value.Value{}}
+	return run(module.env), nil
+}
+
+// unset is kept in a name that was not set yet.
+type unset struct{}
+
+func (u unset) Type() string    { return "" }
+func (u unset) Inspect() string { return "" }
+func (u unset) Equal(other value.Value) bool {
+	_, ok := other.(unset)
+	return ok
+}
+func (u unset) Hash() uint64 { return 0 }
+
+// Unset is the value of a local name before it is set.
+var Unset value.Value = unset{}
+
+// Local returns the value of a local name, which has to be set already.
+func Local(val value.Value, name string, pos Pos) value.Value {
+	if val == Unset {
+		panic(errorAt(pos, "\"%s\" is not defined", name))
+	}
+	return val
+}
+
+// Global returns the value of a name of the global scope.
+func Global(env *value.Environment, name string, pos Pos) value.Value {
+	val, ok := env.Get(name)
+	if !ok {
+		panic(errorAt(pos, "\"%s\" is not defined", name))
+	}
+	return val
+}
+
+// Defined returns the value a name is assigned, which has to be something.
+func Defined(val value.Value, pos Pos) value.Value {
+	if val == nil {
+		panic(errorAt(pos, "value does not produce anything"))
+	}
+	return val
+}
+
+// Last returns the value _ is set to by an expression statement that ended with
+// result, which is the value it had before when the statement was a call in tail position.
+func Last(last value.Value, result value.Value) value.Value {
+	if _, ok := result.(*tailCall); ok {
+		return last
+	}
+	return result
+}
+
+// Publish makes a name of the global scope public.
+func Publish(env *value.Environment, name string) {
+	env.Set("pub "+name, env.Store[name])
+}
+
+// Text joins the parts of a text literal.
+func Text(parts ...value.Value) value.Value {
+	str := ""
+	for _, part := range parts {
+		str = str + part.Inspect()
+	}
+	return value.Text{Value: str}
+}
+
+func Not(right value.Value, pos Pos) value.Value {
+	boolean, ok := right.(value.Boolean)
+	if !ok {
+		panic(errorAt(pos, "non boolean passed to not"))
+	}
+	return value.Boolean{Value: !boolean.Value}
+}
+
+func Negate(right value.Value, pos Pos) value.Value {
+	number, ok := right.(value.Number)
+	if !ok {
+		panic(errorAt(pos, "non number passed to -"))
+	}
+	return value.Number{Value: -number.Value}
+}
+
+// numbers returns the values of two numbers, the operator of which is at pos.
+func numbers(left value.Value, right value.Value, pos Pos) (float64, float64) {
+	if left == nil || right == nil || left.Type() != types.NUMBER || right.Type() != types.NUMBER {
+		panic(errorAt(pos, "expected two numbers, got %s and %s", value.TypeName(left), value.TypeName(right)))
+	}
+	return left.(value.Number).Value, right.(value.Number).Value
+}
+
+func Add(left value.Value, right value.Value, pos Pos) value.Value {
+	l, r := numbers(left, right, pos)
+	return value.Number{Value: l + r}
+}
+
+func Sub(left value.Value, right value.Value, pos Pos) value.Value {
+	l, r := numbers(left, right, pos)
+	return value.Number{Value: l - r}
+}
+
+func Mul(left value.Value, right value.Value, pos Pos) value.Value {
+	l, r := numbers(left, right, pos)
+	return value.Number{Value: l * r}
+}
+
+func Div(left value.Value, right value.Value, pos Pos) value.Value {
+	l, r := numbers(left, right, pos)
+	return value.Number{Value: l / r}
+}
+
+func Greater(left value.Value, right value.Value, pos Pos) value.Value {
+	l, r := numbers(left, right, pos)
+	return value.Boolean{Value: l > r}
+}
+
+func Less(left value.Value, right value.Value, pos Pos) value.Value {
+	l, r := numbers(left, right, pos)
+	return value.Boolean{Value: l < r}
+}
+
+func Equal(left value.Value, right value.Value) value.Value {
+	return value.Boolean{Value: value.Equal(left, right)}
+}
+
+func NotEqual(left value.Value, right value.Value) value.Value {
+	return value.Boolean{Value: !value.Equal(left, right)}
+}
+
+// CheckBool stops the program with message when val is not a boolean.
+func CheckBool(val value.Value, pos Pos, message string) {
+	if _, ok := val.(value.Boolean); !ok {
+		panic(errorAt(pos, "%s", message))
+	}
+}
+
+// IsTrue tells whether a value that was checked to be a boolean is true.
+func IsTrue(val value.Value) bool {
+	return val.(value.Boolean).Value
+}
+
+func And(left value.Value, right value.Value) value.Value {
+	return value.Boolean{Value: IsTrue(left) && IsTrue(right)}
+}
+
+// TableEntry is an entry of a table literal. Entries without a key are numbered
+// in order, spread entries add the entries of another table and computed entries
+// take their key from the values, before their value.
+type TableEntry struct {
+	Key      string
+	Spread   bool
+	Computed bool
+	Pos      Pos
+}
+
+// Table makes a table from the values of the entries of a table literal.
+func Table(entries []TableEntry, values ...value.Value) value.Value {
+	table := value.NewTable()
+	index := -1
+	for _, entry := range entries {
+		val := values[0]
+		values = values[1:]
+		switch {
+		case entry.Computed:
+			if val == nil {
+				panic(errorAt(entry.Pos, "table keys cannot be nothing"))
+			}
+			table = table.Set(val, values[0])
+			values = values[1:]
+		case entry.Key != "":
+			table = table.Set(value.TableKey{Value: entry.Key}, val)
+		case entry.Spread:
+			if val.Type() != types.TABLE {
+				panic(errorAt(entry.Pos, "cannot spread non table values"))
+			}
+			spread := val.(value.Table)
+			table, index = spread.SpreadInto(table, index)
+		default:
+			index += 1
+			table = table.Set(value.Number{Value: float64(index)}, val)
+		}
+	}
+	return table
+}
+
+// Index returns the entry of subject at index. subjectName is how the subject is called
+// when it does not have the entry.
+func Index(subject value.Value, index value.Value, subjectName string, subjectPos Pos, indexPos Pos) value.Value {
+	table, ok := subject.(value.Table)
+	if !ok {
+		panic(errorAt(subjectPos, "Cannot index type %T", subject))
+	}
+	val, ok := table.Entries.Get(index)
+	if !ok {
+		panic(errorAt(indexPos, "%s does not have the \"%s\" attribute", subjectName, index.Inspect()))
+	}
+	return val
+}
+
+// UseBuiltin puts a builtin module and the symbols named from it into env.
+func UseBuiltin(env *value.Environment, name string, symbols ...string) {
+	module, _ := builtinLib.Get(name)
+	env.Set(name, module)
+	for _, symbol := range symbols {
+		val, _ := module.Entries.Get(value.TableKey{Value: symbol})
+		env.Set(symbol, val)
+	}
+}
+
+// UseModule puts the public names of a module into env as a table called name,
+// together with the symbols named from it. The module is run by run the first time it is used.
+func UseModule(env *value.Environment, module *Module, run func(env *value.Environment) value.Value, name string, symbols ...string) {
+	if module.env == nil {
+		module.env = &value.Environment{Store: map[string]value.Value{}}
+		run(module.env)
+	}
+	public := value.NewTable()
+	for key, val := range module.env.Store {
+		if strings.HasPrefix(key, "pub ") {
+			public = public.Set(value.TableKey{Value: strings.TrimPrefix(key, "pub ")}, val)
+		}
+	}
+	env.Set(name, public)
+	for _, symbol := range symbols {
+		if val, ok := module.env.Get("pub " + symbol); ok {
+			env.Set(symbol, val)
+		}
+	}
+}
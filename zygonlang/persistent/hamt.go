@@ -0,0 +1,162 @@
+package persistent
+
+import "math/bits"
+
+const (
+	// levelBits is the number of bits of a hash used by one level of the trie
+	levelBits = 5
+	width     = 1 << levelBits
+	levelMask = width - 1
+	// hashBits is the number of bits of a hash, past them a node lists keys with the same hash
+	hashBits = 64
+)
+
+// hamt is a node of a hash array mapped trie. A node has a slot for each part of
+// the hashes below it whose bit is set in bitmap, which holds either an entry or
+// the node one level below. A node past the last bits of the hashes is a list of
+// the entries whose keys have the same hash.
+type hamt[K any, V any] struct {
+	bitmap uint32
+	slots  []slot[K, V]
+}
+
+// slot is kept small, as changing a node copies all of its slots.
+type slot[K any, V any] struct {
+	node  *hamt[K, V]
+	entry *entry[K, V]
+}
+
+type entry[K any, V any] struct {
+	hash uint64
+	key  K
+	val  V
+	// pos is the place of the key in the insertion order of the map
+	pos int
+}
+
+// index returns the bit of hash at shift and the index of its slot.
+func (n *hamt[K, V]) index(hash uint64, shift uint) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & levelMask)
+	return bit, bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+func (n *hamt[K, V]) get(hash uint64, key K, equal func(K, K) bool, shift uint) (*entry[K, V], bool) {
+	for {
+		if shift >= hashBits {
+			for _, s := range n.slots {
+				if equal(s.entry.key, key) {
+					return s.entry, true
+				}
+			}
+			return nil, false
+		}
+		bit, i := n.index(hash, shift)
+		if n.bitmap&bit == 0 {
+			return nil, false
+		}
+		s := n.slots[i]
+		if s.node == nil {
+			return s.entry, equal(s.entry.key, key)
+		}
+		n = s.node
+		shift += levelBits
+	}
+}
+
+// put returns n with the entry for the key of entry set to it. The position of an entry
+// that is replaced is kept. It tells whether the key is new.
+func (n *hamt[K, V]) put(e *entry[K, V], equal func(K, K) bool, shift uint) (*hamt[K, V], bool) {
+	if shift >= hashBits {
+		for i, s := range n.slots {
+			if equal(s.entry.key, e.key) {
+				e.pos = s.entry.pos
+				return n.with(i, slot[K, V]{entry: e}), false
+			}
+		}
+		return &hamt[K, V]{slots: append(n.slots[:len(n.slots):len(n.slots)], slot[K, V]{entry: e})}, true
+	}
+	bit, i := n.index(e.hash, shift)
+	if n.bitmap&bit == 0 {
+		slots := make([]slot[K, V], 0, len(n.slots)+1)
+		slots = append(slots, n.slots[:i]...)
+		slots = append(slots, slot[K, V]{entry: e})
+		slots = append(slots, n.slots[i:]...)
+		return &hamt[K, V]{bitmap: n.bitmap | bit, slots: slots}, true
+	}
+	s := n.slots[i]
+	switch {
+	case s.node != nil:
+		child, added := s.node.put(e, equal, shift+levelBits)
+		return n.with(i, slot[K, V]{node: child}), added
+	case equal(s.entry.key, e.key):
+		e.pos = s.entry.pos
+		return n.with(i, slot[K, V]{entry: e}), false
+	default:
+		return n.with(i, slot[K, V]{node: join(s.entry, e, shift+levelBits)}), true
+	}
+}
+
+// join returns a node with two entries whose hashes are the same up to shift.
+func join[K any, V any](a *entry[K, V], b *entry[K, V], shift uint) *hamt[K, V] {
+	if shift >= hashBits {
+		return &hamt[K, V]{slots: []slot[K, V]{{entry: a}, {entry: b}}}
+	}
+	bitA := uint32(1) << ((a.hash >> shift) & levelMask)
+	bitB := uint32(1) << ((b.hash >> shift) & levelMask)
+	switch {
+	case bitA == bitB:
+		return &hamt[K, V]{bitmap: bitA, slots: []slot[K, V]{{node: join(a, b, shift+levelBits)}}}
+	case bitA < bitB:
+		return &hamt[K, V]{bitmap: bitA | bitB, slots: []slot[K, V]{{entry: a}, {entry: b}}}
+	default:
+		return &hamt[K, V]{bitmap: bitA | bitB, slots: []slot[K, V]{{entry: b}, {entry: a}}}
+	}
+}
+
+// remove returns n without the entry for key, and the entry that was removed.
+// A node left with a single entry is replaced by the entry in the node above it.
+func (n *hamt[K, V]) remove(hash uint64, key K, equal func(K, K) bool, shift uint) (*hamt[K, V], *entry[K, V], bool) {
+	if shift >= hashBits {
+		for i, s := range n.slots {
+			if equal(s.entry.key, key) {
+				return n.without(0, i), s.entry, true
+			}
+		}
+		return n, nil, false
+	}
+	bit, i := n.index(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, nil, false
+	}
+	s := n.slots[i]
+	if s.node == nil {
+		if !equal(s.entry.key, key) {
+			return n, nil, false
+		}
+		return n.without(bit, i), s.entry, true
+	}
+	child, removed, ok := s.node.remove(hash, key, equal, shift+levelBits)
+	if !ok {
+		return n, nil, false
+	}
+	if len(child.slots) == 1 && child.slots[0].node == nil {
+		return n.with(i, child.slots[0]), removed, true
+	}
+	return n.with(i, slot[K, V]{node: child}), removed, true
+}
+
+// with returns a copy of n with the slot at i replaced.
+func (n *hamt[K, V]) with(i int, s slot[K, V]) *hamt[K, V] {
+	slots := make([]slot[K, V], len(n.slots))
+	copy(slots, n.slots)
+	slots[i] = s
+	return &hamt[K, V]{bitmap: n.bitmap, slots: slots}
+}
+
+// without returns a copy of n without the slot at i, which is for bit.
+func (n *hamt[K, V]) without(bit uint32, i int) *hamt[K, V] {
+	slots := make([]slot[K, V], 0, len(n.slots)-1)
+	slots = append(slots, n.slots[:i]...)
+	slots = append(slots, n.slots[i+1:]...)
+	return &hamt[K, V]{bitmap: n.bitmap &^ bit, slots: slots}
+}
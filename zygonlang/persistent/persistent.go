@@ -0,0 +1,111 @@
+package persistent
+
+// OrderedMap is a map that remembers the order its keys were added in. It is never
+// changed, changing it returns a new map that shares most of its memory with the old one,
+// so a change takes time logarithmic in the size of the map instead of copying it.
+//
+// The entries are kept in a hash array mapped trie by the hashes of their keys,
+// each one with its place in a vector of the keys in the order they were added.
+// Deleting a key leaves a hole in the vector, which is compacted once it has more
+// holes than keys.
+type OrderedMap[K any, V any] struct {
+	hash  func(K) uint64
+	equal func(K, K) bool
+	index *hamt[K, V]
+	order vector[place[K]]
+	len   int
+}
+
+// place is a key in the insertion order of a map.
+type place[K any] struct {
+	key     K
+	deleted bool
+}
+
+// NewOrderedMap returns an empty map whose keys are hashed by hash and compared by equal.
+// Keys that are equal need to have the same hash.
+func NewOrderedMap[K any, V any](hash func(K) uint64, equal func(K, K) bool) *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{hash: hash, equal: equal, index: &hamt[K, V]{}}
+}
+
+// Len returns the number of entries of m.
+func (m *OrderedMap[K, V]) Len() int {
+	return m.len
+}
+
+func (m *OrderedMap[K, V]) Get(key K) (V, bool) {
+	found, ok := m.index.get(m.hash(key), key, m.equal, 0)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return found.val, true
+}
+
+// Set returns m with key set to val. A key that is already in m keeps its place in the order.
+func (m *OrderedMap[K, V]) Set(key K, val V) *OrderedMap[K, V] {
+	index, added := m.index.put(&entry[K, V]{hash: m.hash(key), key: key, val: val, pos: m.order.len}, m.equal, 0)
+	set := &OrderedMap[K, V]{hash: m.hash, equal: m.equal, index: index, order: m.order, len: m.len}
+	if added {
+		set.order = set.order.push(place[K]{key: key})
+		set.len += 1
+	}
+	return set
+}
+
+// Delete returns m without key.
+func (m *OrderedMap[K, V]) Delete(key K) *OrderedMap[K, V] {
+	index, removed, ok := m.index.remove(m.hash(key), key, m.equal, 0)
+	if !ok {
+		return m
+	}
+	deleted := &OrderedMap[K, V]{hash: m.hash, equal: m.equal, index: index, order: m.order.set(removed.pos, place[K]{deleted: true}), len: m.len - 1}
+	return deleted.compact()
+}
+
+// Rename returns m with the key old replaced by new, keeping its value and its place in the order.
+// An entry new already had is deleted.
+func (m *OrderedMap[K, V]) Rename(old K, new K) *OrderedMap[K, V] {
+	if m.equal(old, new) {
+		return m
+	}
+	index, removed, ok := m.index.remove(m.hash(old), old, m.equal, 0)
+	if !ok {
+		return m
+	}
+	order, length := m.order, m.len
+	index, replaced, ok := index.remove(m.hash(new), new, m.equal, 0)
+	if ok {
+		order = order.set(replaced.pos, place[K]{deleted: true})
+		length -= 1
+	}
+	index, _ = index.put(&entry[K, V]{hash: m.hash(new), key: new, val: removed.val, pos: removed.pos}, m.equal, 0)
+	order = order.set(removed.pos, place[K]{key: new})
+	renamed := &OrderedMap[K, V]{hash: m.hash, equal: m.equal, index: index, order: order, len: length}
+	return renamed.compact()
+}
+
+// Keys returns the keys of m in the order they were added.
+func (m *OrderedMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Len())
+	m.order.root.each(func(p place[K]) {
+		if !p.deleted {
+			keys = append(keys, p.key)
+		}
+	})
+	return keys
+}
+
+// compact returns m with the holes left in its order by deleted keys removed,
+// once there are more of them than keys.
+func (m *OrderedMap[K, V]) compact() *OrderedMap[K, V] {
+	if m.order.len-m.len <= m.len {
+		return m
+	}
+	compacted := NewOrderedMap[K, V](m.hash, m.equal)
+	for _, key := range m.Keys() {
+		val, _ := m.Get(key)
+		compacted = compacted.Set(key, val)
+	}
+	return compacted
+}
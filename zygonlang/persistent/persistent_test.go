@@ -0,0 +1,155 @@
+package persistent
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func intEqual(a int, b int) bool { return a == b }
+
+// hashes the maps in the tests are made with: spread out, colliding in their low bits
+// so keys share nodes deep in the trie, and all the same so every key is in one list
+var hashes = map[string]func(int) uint64{
+	"spread":   func(k int) uint64 { return uint64(k) * 0x9e3779b97f4a7c15 },
+	"low bits": func(k int) uint64 { return uint64(k%3) | uint64(k)<<60 },
+	"same":     func(k int) uint64 { return 42 },
+}
+
+// check compares m with the map and order it is expected to have.
+func check(t *testing.T, m *OrderedMap[int, int], want map[int]int, order []int) {
+	t.Helper()
+	if m.Len() != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), m.Len())
+	}
+	if keys := m.Keys(); !slices.Equal(keys, order) {
+		t.Fatalf("expected the keys %v, got %v", order, keys)
+	}
+	for key, val := range want {
+		if got, ok := m.Get(key); !ok || got != val {
+			t.Fatalf("expected %d at %d, got %d, %t", val, key, got, ok)
+		}
+	}
+}
+
+func TestSetGetDelete(t *testing.T) {
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			m := NewOrderedMap[int, int](hash, intEqual)
+			want := map[int]int{}
+			order := []int{}
+			for i := 0; i < 2000; i++ {
+				key := r.Intn(300)
+				if r.Intn(3) == 0 {
+					m = m.Delete(key)
+					if _, ok := want[key]; ok {
+						delete(want, key)
+						order = slices.DeleteFunc(order, func(k int) bool { return k == key })
+					}
+				} else {
+					m = m.Set(key, i)
+					if _, ok := want[key]; !ok {
+						order = append(order, key)
+					}
+					want[key] = i
+				}
+			}
+			check(t, m, want, order)
+			if _, ok := m.Get(1000); ok {
+				t.Fatalf("expected a key that was never set to be missing")
+			}
+		})
+	}
+}
+
+func TestRename(t *testing.T) {
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			m := NewOrderedMap[int, int](hash, intEqual)
+			for key := 0; key < 5; key++ {
+				m = m.Set(key, key*10)
+			}
+			renamed := m.Rename(1, 7)
+			check(t, renamed, map[int]int{0: 0, 7: 10, 2: 20, 3: 30, 4: 40}, []int{0, 7, 2, 3, 4})
+			// renaming onto a key that is there already replaces it
+			renamed = renamed.Rename(2, 4)
+			check(t, renamed, map[int]int{0: 0, 7: 10, 4: 20, 3: 30}, []int{0, 7, 4, 3})
+			// a key that is not there is not renamed
+			if same := renamed.Rename(100, 5); same != renamed {
+				t.Fatalf("expected renaming a missing key to change nothing")
+			}
+			check(t, m, map[int]int{0: 0, 1: 10, 2: 20, 3: 30, 4: 40}, []int{0, 1, 2, 3, 4})
+		})
+	}
+}
+
+func TestCompactKeepsOrder(t *testing.T) {
+	m := NewOrderedMap[int, int](hashes["spread"], intEqual)
+	for key := 0; key < 100; key++ {
+		m = m.Set(key, key)
+	}
+	want := map[int]int{}
+	order := []int{}
+	for key := 0; key < 100; key++ {
+		if key%10 == 3 {
+			want[key] = key
+			order = append(order, key)
+		} else {
+			m = m.Delete(key)
+		}
+	}
+	check(t, m, want, order)
+	// the holes deleted keys leave are removed once there are more of them than keys
+	if holes := m.order.len - m.Len(); holes > m.Len() {
+		t.Fatalf("expected the order to be compacted, it has %d holes for %d keys", holes, m.Len())
+	}
+}
+
+func TestChangesShareStructure(t *testing.T) {
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			m := NewOrderedMap[int, int](hash, intEqual)
+			for key := 0; key < 100; key++ {
+				m = m.Set(key, key)
+			}
+			want := map[int]int{}
+			order := []int{}
+			for key := 0; key < 100; key++ {
+				want[key] = key
+				order = append(order, key)
+			}
+			set := m.Set(50, -1).Set(100, 100)
+			deleted := m.Delete(50)
+			renamed := m.Rename(50, 200)
+			// the map that was changed stays the same
+			check(t, m, want, order)
+			if val, _ := set.Get(50); val != -1 || set.Len() != 101 {
+				t.Fatalf("expected the changed map to have the new values")
+			}
+			if _, ok := deleted.Get(50); ok || deleted.Len() != 99 {
+				t.Fatalf("expected the key to be deleted")
+			}
+			if val, ok := renamed.Get(200); !ok || val != 50 {
+				t.Fatalf("expected the key to be renamed")
+			}
+			// a change copies only the nodes on the way to the key it changes
+			if name == "spread" && !sharesNodes(m.index, set.index) {
+				t.Fatalf("expected the changed map to share nodes with the old one")
+			}
+			if m.order.root.children[0] != deleted.order.root.children[0] && m.order.root.children[0] != set.order.root.children[0] {
+				t.Fatalf("expected the order to share nodes with the old one")
+			}
+		})
+	}
+}
+
+// sharesNodes tells whether a and b have a child node in common.
+func sharesNodes[K any, V any](a *hamt[K, V], b *hamt[K, V]) bool {
+	for _, s := range a.slots {
+		if s.node != nil && slices.ContainsFunc(b.slots, func(other slot[K, V]) bool { return other.node == s.node }) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package persistent
+
+// vector is a list that shares the parts it did not change with the lists it was
+// made from. Its items are kept in the leaves of a trie, each inner node has
+// width children and each leaf width items.
+type vector[T any] struct {
+	root *vnode[T]
+	// shift is the number of bits of an index used below the root
+	shift uint
+	len   int
+}
+
+type vnode[T any] struct {
+	children []*vnode[T]
+	items    []T
+}
+
+// set returns v with the item at i replaced.
+func (v vector[T]) set(i int, item T) vector[T] {
+	v.root = v.root.set(v.shift, i, item)
+	return v
+}
+
+// push returns v with item added to its end.
+func (v vector[T]) push(item T) vector[T] {
+	if v.root != nil && v.len == 1<<(v.shift+levelBits) {
+		v.root = &vnode[T]{children: []*vnode[T]{v.root}}
+		v.shift += levelBits
+	}
+	v.root = v.root.set(v.shift, v.len, item)
+	v.len += 1
+	return v
+}
+
+// set returns a copy of n with the item at i replaced, adding the nodes on the way to it
+// that are missing.
+func (n *vnode[T]) set(shift uint, i int, item T) *vnode[T] {
+	if n == nil {
+		n = &vnode[T]{}
+	}
+	j := (i >> shift) & levelMask
+	if shift == 0 {
+		items := make([]T, max(len(n.items), j+1))
+		copy(items, n.items)
+		items[j] = item
+		return &vnode[T]{items: items}
+	}
+	children := make([]*vnode[T], max(len(n.children), j+1))
+	copy(children, n.children)
+	children[j] = children[j].set(shift-levelBits, i, item)
+	return &vnode[T]{children: children}
+}
+
+// each calls fn with the items of n in order.
+func (n *vnode[T]) each(fn func(T)) {
+	if n == nil {
+		return
+	}
+	for _, item := range n.items {
+		fn(item)
+	}
+	for _, child := range n.children {
+		child.each(fn)
+	}
+}
@@ -0,0 +1,174 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"thechosenzendro/zygonlang/zygonlang/analyzer"
+	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/builtin"
+	"thechosenzendro/zygonlang/zygonlang/diagnostic"
+	"thechosenzendro/zygonlang/zygonlang/evaluator"
+	"thechosenzendro/zygonlang/zygonlang/token"
+	"thechosenzendro/zygonlang/zygonlang/types"
+	"thechosenzendro/zygonlang/zygonlang/value"
+)
+
+const (
+	prompt             = ">> "
+	continuationPrompt = ".. "
+)
+
+// Start reads code from in, runs it and writes the results to out until in ends.
+// Everything declared in one input can be used in the inputs after it.
+// Modules are loaded with loader, looking from the working directory.
+func Start(in io.Reader, out io.Writer, loader *evaluator.Loader) {
+	scanner := bufio.NewScanner(in)
+	env := &value.Environment{Store: map[string]value.Value{}, Outer: nil}
+	typeEnv := &types.TypeEnvironment{Store: map[string]*types.Type{}, Outer: nil}
+
+	for {
+		input, ok := readInput(scanner, out)
+		if !ok {
+			fmt.Fprintln(out)
+			return
+		}
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+
+		result, err := run(loader, input, env, typeEnv)
+		if err != nil {
+			var crash *builtin.Crash
+			if errors.As(err, &crash) {
+				fmt.Fprintln(out, crash.Error())
+			} else {
+				fmt.Fprint(out, diagnostic.Render("repl", input, err))
+			}
+			continue
+		}
+		if result != nil {
+			fmt.Fprintln(out, result.Inspect())
+		}
+	}
+}
+
+func run(loader *evaluator.Loader, input string, env *value.Environment, typeEnv *types.TypeEnvironment) (value.Value, error) {
+	// the lexer needs to lex indents correctly
+	tokens, err := token.Tokenize(input + "\n")
+	if err != nil {
+		return nil, err
+	}
+	program, err := ast.Parse(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	program, err = analyzer.Analyze(program, typeEnv)
+	if err != nil {
+		return nil, err
+	}
+	result, err := loader.Run("", program, env)
+	if err != nil {
+		return nil, err
+	}
+	if len(program.Body) == 0 {
+		return nil, nil
+	}
+	// declarations are not echoed back
+	switch program.Body[len(program.Body)-1].(type) {
+	case ast.AssignmentStatement, ast.FunctionDeclaration, ast.UsingStatement, ast.PubStatement:
+		return nil, nil
+	}
+	return result, nil
+}
+
+// readInput reads one input, which can span multiple lines. An input goes on while
+// a ( or { is left open, and a line ending with : starts a block that goes on until
+// an empty line. It returns false when there is nothing more to read.
+func readInput(scanner *bufio.Scanner, out io.Writer) (string, bool) {
+	lines := []string{}
+	inBlock := false
+	for {
+		if len(lines) == 0 {
+			fmt.Fprint(out, prompt)
+		} else {
+			fmt.Fprint(out, continuationPrompt)
+		}
+		if !scanner.Scan() {
+			if len(lines) == 0 {
+				return "", false
+			}
+			return strings.Join(lines, "\n"), true
+		}
+		line := scanner.Text()
+		if inBlock && strings.TrimSpace(line) == "" {
+			break
+		}
+		lines = append(lines, line)
+
+		code := stripComment(line)
+		if strings.HasSuffix(strings.TrimSpace(code), ":") {
+			inBlock = true
+		}
+		if !inBlock && openBrackets(strings.Join(lines, "\n")) <= 0 {
+			break
+		}
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// openBrackets counts the ( and { that are not closed yet, skipping text and comments.
+func openBrackets(code string) int {
+	open := 0
+	inText := false
+	inComment := false
+	escaped := false
+	for _, r := range code {
+		switch {
+		case inComment:
+			if r == '\n' {
+				inComment = false
+			}
+		case inText:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == '"' {
+				inText = false
+			}
+		case r == '"':
+			inText = true
+		case r == '#':
+			inComment = true
+		case r == '(' || r == '{':
+			open += 1
+		case r == ')' || r == '}':
+			open -= 1
+		}
+	}
+	return open
+}
+
+func stripComment(line string) string {
+	inText := false
+	escaped := false
+	for i, r := range line {
+		if inText {
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == '"' {
+				inText = false
+			}
+		} else if r == '"' {
+			inText = true
+		} else if r == '#' {
+			return line[:i]
+		}
+	}
+	return line
+}
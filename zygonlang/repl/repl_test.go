@@ -0,0 +1,62 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"thechosenzendro/zygonlang/zygonlang/evaluator"
+)
+
+func TestSession(t *testing.T) {
+	script := `x: 1
+x + 1
+f(a):
+    a * 2
+
+f(x)
+x: 2
+{1,
+2}
+y
+f(x) # the session goes on after errors
+`
+	// the prompt is written again when the script ends
+	want := `>> >> 2
+>> .. .. >> 2
+>>    _____repl_____
+1 │ x: 2
+    ^ Cannot reassign identifier x
+>> .. {
+    0: 1
+    1: 2
+}
+
+>>    _____repl_____
+1 │ y
+    ^ "y" is not defined
+>> 2
+` + prompt + "\n"
+	for _, useVM := range []bool{false, true} {
+		loader := evaluator.NewLoader(nil)
+		loader.UseVM = useVM
+		var out strings.Builder
+		Start(strings.NewReader(script), &out, loader)
+		if out.String() != want {
+			t.Errorf("vm: %t: expected\n%s\ngot\n%s", useVM, want, out.String())
+		}
+	}
+}
+
+func TestOpenBrackets(t *testing.T) {
+	tests := map[string]int{
+		"f(1, {2":            2,
+		"f(1, {2})":          0,
+		`f("(", "{")`:        0,
+		`f("\"(")`:           0,
+		"f( # a comment ( {": 1,
+	}
+	for code, want := range tests {
+		if got := openBrackets(code); got != want {
+			t.Errorf("%q: expected %d open brackets, got %d", code, want, got)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package token
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
 	"thechosenzendro/zygonlang/zygonlang/stream"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType string
@@ -23,6 +26,7 @@ const (
 	LBRACE       = "LBRACE"
 	RBRACE       = "RBRACE"
 	CASE         = "CASE"
+	CHAIN        = "CHAIN"
 	TEXT_START   = "TEXT_START"
 	TEXT_PART    = "TEXT_PART"
 	TEXT_END     = "TEXT_END"
@@ -34,6 +38,7 @@ const (
 	NOT          = "NOT"
 	AND          = "AND"
 	OR           = "OR"
+	PIPE         = "PIPE"
 	LESSER_THAN  = "LESSER_THAN"
 	GREATER_THAN = "GREATER_THAN"
 	EOL          = "EOL"
@@ -43,26 +48,112 @@ const (
 	FALSE        = "FALSE"
 	DOT          = "DOT"
 	DEFAULT      = "DEFAULT"
+	IF           = "IF"
 	REST         = "REST"
+	COMMENT      = "COMMENT"
 )
 
+// Position is a location in the source code.
+// Line and Column are 1-based, Column counts runes and Offset is in bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// Span is the part of the source code from Start up to (excluding) End.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Range returns the span itself, so anything embedding a Span can report where it is.
+func (s Span) Range() Span { return s }
+
 type Token struct {
 	Type  TokenType
 	Value string
+	Span  Span
+}
+
+// LexError is a problem with the source code found while splitting it into tokens.
+type LexError struct {
+	Span
+	Message string
+}
+
+func (e *LexError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Start.Line, e.Start.Column, e.Message)
+}
+func (e *LexError) Msg() string { return e.Message }
+
+// sourceMap translates rune indexes of the source into positions.
+type sourceMap struct {
+	lineStarts []int
+	offsets    []int
 }
 
-var parenLevel = 0
-var braceLevel = 0
-var indentLevel = []int{0}
+func newSourceMap(source []rune) sourceMap {
+	m := sourceMap{lineStarts: []int{0}, offsets: make([]int, len(source)+1)}
+	offset := 0
+	for i, r := range source {
+		m.offsets[i] = offset
+		offset += utf8.RuneLen(r)
+		if r == '\n' {
+			m.lineStarts = append(m.lineStarts, i+1)
+		}
+	}
+	m.offsets[len(source)] = offset
+	return m
+}
+
+func (m sourceMap) position(index int) Position {
+	if index >= len(m.offsets) {
+		index = len(m.offsets) - 1
+	}
+	line := sort.Search(len(m.lineStarts), func(i int) bool { return m.lineStarts[i] > index }) - 1
+	return Position{Offset: m.offsets[index], Line: line + 1, Column: index - m.lineStarts[line] + 1}
+}
+
+func (m sourceMap) span(start int, end int) Span {
+	return Span{Start: m.position(start), End: m.position(end)}
+}
+
+// Lexer splits one source into tokens. Each Lexer has its own state,
+// so different sources can be lexed independently and concurrently.
+type Lexer struct {
+	// KeepComments makes the lexer emit COMMENT tokens instead of skipping comments.
+	KeepComments bool
+
+	source      *stream.Stream[rune]
+	positions   sourceMap
+	parenLevel  int
+	braceLevel  int
+	indentLevel []int
+}
 
-func Tokenize(sourceCode string) stream.Stream[Token] {
+func NewLexer(sourceCode string) *Lexer {
 	source := &stream.Stream[rune]{Index: 0, Contents: []rune(sourceCode)}
+	return &Lexer{source: source, positions: newSourceMap(source.Contents), indentLevel: []int{0}}
+}
+
+// Tokenize lexes sourceCode with a new Lexer.
+func Tokenize(sourceCode string) (stream.Stream[Token], error) {
+	return NewLexer(sourceCode).Tokenize()
+}
+
+func (l *Lexer) Tokenize() (stream.Stream[Token], error) {
 	tokens := &stream.Stream[Token]{Index: 0, Contents: []Token{}}
-	for source.Peek(0) != nil {
-		tokens.Contents = append(tokens.Contents, lexToken(source)...)
+	for l.source.Peek(0) != nil {
+		lexed, err := l.lexToken()
+		if err != nil {
+			return *tokens, err
+		}
+		tokens.Contents = append(tokens.Contents, lexed...)
 	}
-	tokens.Contents = append(tokens.Contents, Token{EOF, ""})
-	return *tokens
+	end := len(l.source.Contents)
+	tokens.Contents = append(tokens.Contents, Token{Type: EOF, Value: "", Span: l.positions.span(end, end)})
+	return *tokens, nil
 }
 
 func IsToken(tokens *stream.Stream[Token], tokenType TokenType, amount int) bool {
@@ -73,15 +164,24 @@ func IsToken(tokens *stream.Stream[Token], tokenType TokenType, amount int) bool
 	}
 	return tokens.Contents[index].Type == tokenType
 }
-func lexToken(source *stream.Stream[rune]) []Token {
+func (l *Lexer) lexToken() ([]Token, error) {
+	source, positions := l.source, l.positions
 	tokens := []Token{}
+	start := source.Index
+	// emit appends a token spanning from the start of the lexeme to the current position
+	emit := func(tokenType TokenType, value string) {
+		tokens = append(tokens, Token{Type: tokenType, Value: value, Span: positions.span(start, source.Index)})
+	}
 
 	switch {
 
 	case *source.Peek(0) == '#':
-		for *source.Peek(0) != '\n' {
+		for source.Peek(0) != nil && *source.Peek(0) != '\n' {
 			source.Consume(1)
 		}
+		if l.KeepComments {
+			emit(COMMENT, string(source.Contents[start:source.Index]))
+		}
 	case unicode.IsLetter(*source.Peek(0)) || *source.Peek(0) == '_':
 		buf := []rune{}
 		for source.Peek(0) != nil && (unicode.IsLetter(*source.Peek(0)) || *source.Peek(0) == '_' || unicode.IsDigit(*source.Peek(0))) {
@@ -89,36 +189,40 @@ func lexToken(source *stream.Stream[rune]) []Token {
 			source.Consume(1)
 		}
 		if string(buf) == "case" {
-			tokens = append(tokens, Token{CASE, "case"})
+			emit(CASE, "case")
+		} else if string(buf) == "chain" {
+			emit(CHAIN, "chain")
 		} else if string(buf) == "is" {
-			tokens = append(tokens, Token{IS, "is"})
+			emit(IS, "is")
 		} else if string(buf) == "not" {
-			tokens = append(tokens, Token{NOT, "not"})
+			emit(NOT, "not")
 		} else if string(buf) == "and" {
-			tokens = append(tokens, Token{AND, "and"})
+			emit(AND, "and")
 		} else if string(buf) == "or" {
-			tokens = append(tokens, Token{OR, "or"})
+			emit(OR, "or")
 		} else if string(buf) == "pub" {
-			tokens = append(tokens, Token{PUB, "pub"})
+			emit(PUB, "pub")
 		} else if string(buf) == "using" {
-			tokens = append(tokens, Token{USING, "using"})
+			emit(USING, "using")
 		} else if string(buf) == "true" {
-			tokens = append(tokens, Token{TRUE, "true"})
+			emit(TRUE, "true")
 		} else if string(buf) == "false" {
-			tokens = append(tokens, Token{FALSE, "false"})
+			emit(FALSE, "false")
 		} else if string(buf) == "default" {
-			tokens = append(tokens, Token{DEFAULT, "default"})
+			emit(DEFAULT, "default")
+		} else if string(buf) == "if" {
+			emit(IF, "if")
 		} else {
-			tokens = append(tokens, Token{IDENT, string(buf)})
+			emit(IDENT, string(buf))
 
 		}
 	case *source.Peek(0) == '.':
-		if *source.Peek(1) == '.' && *source.Peek(2) == '.' {
-			tokens = append(tokens, Token{REST, "..."})
+		if source.Peek(2) != nil && *source.Peek(1) == '.' && *source.Peek(2) == '.' {
 			source.Consume(3)
+			emit(REST, "...")
 		} else {
-			tokens = append(tokens, Token{DOT, "."})
 			source.Consume(1)
+			emit(DOT, ".")
 		}
 	case unicode.IsDigit(*source.Peek(0)):
 		buf := []rune{}
@@ -126,7 +230,7 @@ func lexToken(source *stream.Stream[rune]) []Token {
 		for source.Peek(0) != nil && (unicode.IsDigit(*source.Peek(0)) || *source.Peek(0) == '_' || *source.Peek(0) == '.') {
 			if *source.Peek(0) == '.' {
 				if hasDecimal {
-					panic("Number literal cannot have more decimal parts")
+					return nil, &LexError{Span: positions.span(source.Index, source.Index+1), Message: "Number literal cannot have more decimal parts"}
 				} else {
 					hasDecimal = true
 				}
@@ -139,44 +243,54 @@ func lexToken(source *stream.Stream[rune]) []Token {
 			}
 		}
 		if buf[len(buf)-1] == '.' {
-			panic("Expected fractional part after DOT in number literal")
+			return nil, &LexError{Span: positions.span(source.Index-1, source.Index), Message: "Expected fractional part after DOT in number literal"}
 		}
-		tokens = append(tokens, Token{NUM, string(buf)})
+		emit(NUM, string(buf))
 
 	case *source.Peek(0) == '"':
 		source.Consume(1)
-		tokens = append(tokens, Token{TEXT_START, ""})
+		emit(TEXT_START, "")
 		buf := []rune{}
+		partStart := source.Index
 		for {
 			if source.Peek(0) == nil {
-				panic("Unterminated text literal")
+				return nil, &LexError{Span: positions.span(start, start+1), Message: "Unterminated text literal"}
 			}
 			if *source.Peek(0) == '"' {
 				break
 			} else if *source.Peek(0) == '{' {
-				braceLevel += 1
-				bl := braceLevel
-				tokens = append(tokens, Token{TEXT_PART, string(buf)})
+				l.braceLevel += 1
+				bl := l.braceLevel
+				interpolationStart := source.Index
+				tokens = append(tokens, Token{Type: TEXT_PART, Value: string(buf), Span: positions.span(partStart, source.Index)})
 				buf = []rune{}
 				source.Consume(1)
-				for *source.Peek(0) != '}' && braceLevel == bl {
+				for source.Peek(0) == nil || (*source.Peek(0) != '}' && l.braceLevel == bl) {
 					i := 0
 					for {
 						if source.Peek(i) == nil {
-							panic("Unterminated interpolation in text literal")
+							return nil, &LexError{Span: positions.span(interpolationStart, interpolationStart+1), Message: "Unterminated interpolation in text literal"}
 						}
-						if *source.Peek(i) == '}' && braceLevel == bl {
+						if *source.Peek(i) == '}' && l.braceLevel == bl {
 							break
 						}
 						i += 1
 					}
-					tokens = append(tokens, lexToken(source)...)
+					lexed, err := l.lexToken()
+					if err != nil {
+						return nil, err
+					}
+					tokens = append(tokens, lexed...)
 				}
 				source.Consume(1)
-				braceLevel -= 1
+				l.braceLevel -= 1
+				partStart = source.Index
 
 			} else if *source.Peek(0) == '\\' {
 				source.Consume(1)
+				if source.Peek(0) == nil {
+					return nil, &LexError{Span: positions.span(start, start+1), Message: "Unterminated text literal"}
+				}
 				if *source.Peek(0) == 'n' {
 					buf = append(buf, '\n')
 				} else if *source.Peek(0) == 't' {
@@ -190,101 +304,114 @@ func lexToken(source *stream.Stream[rune]) []Token {
 				source.Consume(1)
 			}
 		}
+		tokens = append(tokens, Token{Type: TEXT_PART, Value: string(buf), Span: positions.span(partStart, source.Index)})
 		source.Consume(1)
-		tokens = append(tokens, Token{TEXT_PART, string(buf)})
-		tokens = append(tokens, Token{TEXT_END, ""})
+		tokens = append(tokens, Token{Type: TEXT_END, Value: "", Span: positions.span(source.Index-1, source.Index)})
 
 	case *source.Peek(0) != '\n' && unicode.IsSpace(*source.Peek(0)):
 		source.Consume(1)
 
 	case *source.Peek(0) == '\n':
 		source.Consume(1)
-		if parenLevel == 0 {
-			tokens = append(tokens, Token{EOL, "\\n"})
-			currentIndentLevel := 0
-
-			if source.Peek(0) != nil {
-				for {
-					if *source.Peek(0) == ' ' {
-						currentIndentLevel += 1
-						source.Consume(1)
-
-					} else if *source.Peek(0) == '\t' {
+		if l.parenLevel == 0 {
+			emit(EOL, "\\n")
+			var currentIndentLevel, indentStart int
+			for {
+				currentIndentLevel = 0
+				indentStart = source.Index
+				for source.Peek(0) != nil && (*source.Peek(0) == ' ' || *source.Peek(0) == '\t' || *source.Peek(0) == '\r') {
+					if *source.Peek(0) == '\t' {
 						currentIndentLevel += 4
-						source.Consume(1)
-
-					} else {
-						break
+					} else if *source.Peek(0) == ' ' {
+						currentIndentLevel += 1
 					}
+					source.Consume(1)
+				}
+				// empty lines and lines with only a comment are skipped, unless comments are kept
+				if l.KeepComments || source.Peek(0) == nil || (*source.Peek(0) != '\n' && *source.Peek(0) != '#') {
+					break
+				}
+				for source.Peek(0) != nil && *source.Peek(0) != '\n' {
+					source.Consume(1)
+				}
+				if source.Peek(0) != nil {
+					source.Consume(1)
 				}
 			}
-			for {
-				if currentIndentLevel == indentLevel[len(indentLevel)-1] {
+			indentSpan := positions.span(indentStart, source.Index)
+			// when comments are kept, empty lines and lines with only a comment do not change the indentation
+			blank := source.Peek(0) != nil && (*source.Peek(0) == '\n' || *source.Peek(0) == '#')
+			for !blank {
+				if currentIndentLevel == l.indentLevel[len(l.indentLevel)-1] {
 					break
 				}
-				if currentIndentLevel > indentLevel[len(indentLevel)-1] {
-					indentLevel = append(indentLevel, currentIndentLevel)
-					tokens = append(tokens, Token{INDENT, strconv.Itoa(currentIndentLevel)})
-				} else if currentIndentLevel < indentLevel[len(indentLevel)-1] {
-					tokens = append(tokens, Token{DEDENT, strconv.Itoa(indentLevel[len(indentLevel)-1])})
-					indentLevel = indentLevel[:len(indentLevel)-1]
+				if currentIndentLevel > l.indentLevel[len(l.indentLevel)-1] {
+					l.indentLevel = append(l.indentLevel, currentIndentLevel)
+					tokens = append(tokens, Token{Type: INDENT, Value: strconv.Itoa(currentIndentLevel), Span: indentSpan})
+				} else if currentIndentLevel < l.indentLevel[len(l.indentLevel)-1] {
+					tokens = append(tokens, Token{Type: DEDENT, Value: strconv.Itoa(l.indentLevel[len(l.indentLevel)-1]), Span: indentSpan})
+					l.indentLevel = l.indentLevel[:len(l.indentLevel)-1]
 				}
 			}
 
 		}
 
 	case *source.Peek(0) == '(':
-		parenLevel += 1
-		tokens = append(tokens, Token{LPAREN, strconv.Itoa(parenLevel)})
+		l.parenLevel += 1
 		source.Consume(1)
+		emit(LPAREN, strconv.Itoa(l.parenLevel))
 
 	case *source.Peek(0) == ')':
-		parenLevel -= 1
-		tokens = append(tokens, Token{RPAREN, strconv.Itoa(parenLevel + 1)})
+		l.parenLevel -= 1
 		source.Consume(1)
+		emit(RPAREN, strconv.Itoa(l.parenLevel+1))
 
 	case *source.Peek(0) == '{':
-		braceLevel += 1
-		tokens = append(tokens, Token{LBRACE, strconv.Itoa(braceLevel)})
+		l.braceLevel += 1
 		source.Consume(1)
+		emit(LBRACE, strconv.Itoa(l.braceLevel))
 
 	case *source.Peek(0) == '}':
-		braceLevel -= 1
-		tokens = append(tokens, Token{RBRACE, strconv.Itoa(braceLevel + 1)})
+		l.braceLevel -= 1
 		source.Consume(1)
+		emit(RBRACE, strconv.Itoa(l.braceLevel+1))
 
 	case *source.Peek(0) == ',':
-		tokens = append(tokens, Token{COMMA, ","})
 		source.Consume(1)
+		emit(COMMA, ",")
 
 	case *source.Peek(0) == '+':
-		tokens = append(tokens, Token{PLUS, "+"})
 		source.Consume(1)
+		emit(PLUS, "+")
 
 	case *source.Peek(0) == '-':
-		tokens = append(tokens, Token{MINUS, "-"})
 		source.Consume(1)
+		emit(MINUS, "-")
 
 	case *source.Peek(0) == '*':
-		tokens = append(tokens, Token{STAR, "*"})
 		source.Consume(1)
+		emit(STAR, "*")
 
 	case *source.Peek(0) == '/':
-		tokens = append(tokens, Token{SLASH, "/"})
 		source.Consume(1)
+		emit(SLASH, "/")
 
 	case *source.Peek(0) == ':':
-		tokens = append(tokens, Token{COLON, ":"})
 		source.Consume(1)
+		emit(COLON, ":")
+	case *source.Peek(0) == '|':
+		source.Consume(1)
+		emit(PIPE, "|")
 	case *source.Peek(0) == '<':
-		tokens = append(tokens, Token{LESSER_THAN, "<"})
 		source.Consume(1)
+		emit(LESSER_THAN, "<")
 	case *source.Peek(0) == '>':
-		tokens = append(tokens, Token{GREATER_THAN, ">"})
 		source.Consume(1)
+		emit(GREATER_THAN, ">")
 	default:
-		tokens = append(tokens, Token{UNKNOWN, string(*source.Peek(0))})
+		char := string(*source.Peek(0))
 		source.Consume(1)
+		emit(UNKNOWN, char)
 	}
-	return tokens
+	return tokens, nil
 }
@@ -0,0 +1,30 @@
+package token
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTokenizeTrailingDots(t *testing.T) {
+	for _, sourceCode := range []string{"x.", "x.."} {
+		tokens, err := Tokenize(sourceCode)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", sourceCode, err)
+		}
+		last := tokens.Contents[len(tokens.Contents)-2]
+		if last.Type != DOT || tokens.Contents[len(tokens.Contents)-1].Type != EOF {
+			t.Fatalf("%q: expected it to end with DOT and EOF, got %v", sourceCode, tokens.Contents)
+		}
+	}
+}
+
+func TestTokenizeUnterminatedInterpolation(t *testing.T) {
+	_, err := Tokenize(`"a{`)
+	var lexErr *LexError
+	if !errors.As(err, &lexErr) {
+		t.Fatalf("expected a LexError, got %v", err)
+	}
+	if lexErr.Message != "Unterminated interpolation in text literal" {
+		t.Fatalf("unexpected message %q", lexErr.Message)
+	}
+}
@@ -24,6 +24,9 @@ type BaseType string
 type Type struct {
 	Base       BaseType
 	Properties *orderedmap.OrderedMap[string, *Type]
+	// Names is the type a value of the type Type stands for, like Number for Type.number,
+	// when it is known
+	Names *Type
 }
 
 func indent(tableIndentLevel int) string {
@@ -55,6 +58,31 @@ func (t *Type) Inspect(indentLevel int) string {
 	return out.String()
 }
 
+// Equal tells whether t and other are the same type, either of which can be nil for Any.
+// Tables with the same entries in another order are the same type.
+func (t *Type) Equal(other *Type) bool {
+	if t == nil || other == nil {
+		return t == nil && other == nil
+	}
+	if t.Base != other.Base || !t.Names.Equal(other.Names) {
+		return false
+	}
+	if t.Properties == nil || other.Properties == nil {
+		return t.Properties == nil && other.Properties == nil
+	}
+	if t.Properties.Len() != other.Properties.Len() {
+		return false
+	}
+	for _, key := range t.Properties.Keys() {
+		typ, _ := t.Properties.Get(key)
+		otherTyp, ok := other.Properties.Get(key)
+		if !ok || !typ.Equal(otherTyp) {
+			return false
+		}
+	}
+	return true
+}
+
 func NewType(base BaseType, properties *orderedmap.OrderedMap[string, *Type]) *Type {
 	return &Type{Base: base, Properties: properties}
 }
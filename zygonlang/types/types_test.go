@@ -0,0 +1,41 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+func table(keys ...string) *Type {
+	properties := orderedmap.NewOrderedMap[string, *Type]()
+	for _, key := range keys {
+		properties.Set(key, NewType(NUMBER, nil))
+	}
+	return NewType(TABLE, properties)
+}
+
+func TestEqual(t *testing.T) {
+	same := [][2]*Type{
+		{nil, nil},
+		{NewType(NUMBER, nil), NewType(NUMBER, nil)},
+		{table("x", "y"), table("y", "x")},
+		{&Type{Base: TYPE, Names: NewType(TEXT, nil)}, &Type{Base: TYPE, Names: NewType(TEXT, nil)}},
+	}
+	for _, pair := range same {
+		if !pair[0].Equal(pair[1]) {
+			t.Errorf("expected %v and %v to be the same type", pair[0], pair[1])
+		}
+	}
+	different := [][2]*Type{
+		{NewType(NUMBER, nil), nil},
+		{NewType(NUMBER, nil), NewType(TEXT, nil)},
+		{table("x"), table("x", "y")},
+		{table("x"), NewType(TABLE, nil)},
+		{&Type{Base: TYPE, Names: NewType(TEXT, nil)}, &Type{Base: TYPE, Names: NewType(NUMBER, nil)}},
+	}
+	for _, pair := range different {
+		if pair[0].Equal(pair[1]) || pair[1].Equal(pair[0]) {
+			t.Errorf("expected %v and %v to be different types", pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package value
+
+import "unsafe"
+
+// Equal tells whether two values are the same, either of which can be nil.
+func Equal(left Value, right Value) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	return left.Equal(right)
+}
+
+// Hash returns the hash of a value, which can be nil.
+func Hash(val Value) uint64 {
+	if val == nil {
+		return 0
+	}
+	return val.Hash()
+}
+
+// FunctionHash returns the hash of a function, which is only equal to itself. identity is
+// the pointer that tells it apart from other functions, the one its Equal compares.
+func FunctionHash[T any](identity *T) uint64 {
+	return hashBits(kindFunction, uint64(uintptr(unsafe.Pointer(identity))))
+}
+
+// the kind of a value is hashed before it, so values of different kinds rarely collide
+const (
+	kindNumber byte = iota + 1
+	kindBoolean
+	kindText
+	kindTableKey
+	kindTable
+	kindEntry
+	kindFunction
+	kindError
+	kindType
+)
+
+// hashes are FNV-1a
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+func hashText(kind byte, text string) uint64 {
+	h := (fnvOffset ^ uint64(kind)) * fnvPrime
+	for i := 0; i < len(text); i++ {
+		h = (h ^ uint64(text[i])) * fnvPrime
+	}
+	return h
+}
+
+func hashBits(kind byte, bits uint64) uint64 {
+	h := (fnvOffset ^ uint64(kind)) * fnvPrime
+	for i := 0; i < 8; i++ {
+		h = (h ^ (bits & 0xff)) * fnvPrime
+		bits >>= 8
+	}
+	return h
+}
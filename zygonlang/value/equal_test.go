@@ -0,0 +1,83 @@
+package value
+
+import (
+	"math"
+	"testing"
+
+	"github.com/elliotchance/orderedmap/v2"
+)
+
+func table(entries ...Value) Table {
+	t := NewTable()
+	for i := 0; i < len(entries); i += 2 {
+		t = t.Set(entries[i], entries[i+1])
+	}
+	return t
+}
+
+func TestEqualValuesHashEqually(t *testing.T) {
+	pairs := []struct {
+		name        string
+		left, right Value
+	}{
+		{"zero and negative zero", Number{Value: 0}, Number{Value: math.Copysign(0, -1)}},
+		{"NaNs", Number{Value: math.NaN()}, Number{Value: -math.NaN()}},
+		{"texts", Text{Value: "hello"}, Text{Value: "hello"}},
+		{"table keys", TableKey{Value: "name"}, TableKey{Value: "name"}},
+		{"tables in another order", table(TableKey{Value: "a"}, Number{Value: 1}, TableKey{Value: "b"}, Text{Value: "x"}), table(TableKey{Value: "b"}, Text{Value: "x"}, TableKey{Value: "a"}, Number{Value: 1})},
+		{"nested tables", table(Number{Value: 0}, table(TableKey{Value: "x"}, Number{Value: 1})), table(Number{Value: 0}, table(TableKey{Value: "x"}, Number{Value: 1}))},
+		{"tables as keys", table(table(Number{Value: 0}, Boolean{Value: true}), Text{Value: "key"}), table(table(Number{Value: 0}, Boolean{Value: true}), Text{Value: "key"})},
+	}
+	for _, pair := range pairs {
+		if !Equal(pair.left, pair.right) {
+			t.Errorf("%s: expected %s and %s to be equal", pair.name, pair.left.Inspect(), pair.right.Inspect())
+		}
+		if Hash(pair.left) != Hash(pair.right) {
+			t.Errorf("%s: expected %s and %s to hash equally", pair.name, pair.left.Inspect(), pair.right.Inspect())
+		}
+	}
+}
+
+func TestDifferentValues(t *testing.T) {
+	pairs := [][2]Value{
+		{Number{Value: 1}, Text{Value: "1"}},
+		{Text{Value: "a"}, TableKey{Value: "a"}},
+		{table(Number{Value: 0}, Number{Value: 1}), table(Number{Value: 0}, Number{Value: 2})},
+		{table(Number{Value: 0}, table()), table(Number{Value: 0}, table(Number{Value: 0}, Number{Value: 0}))},
+		{Number{Value: 0}, nil},
+	}
+	for _, pair := range pairs {
+		if Equal(pair[0], pair[1]) {
+			t.Errorf("expected %v and %v to be different", pair[0], pair[1])
+		}
+	}
+}
+
+func TestNaNKeys(t *testing.T) {
+	tbl := table(Number{Value: math.NaN()}, Text{Value: "first"}, Number{Value: math.NaN()}, Text{Value: "second"})
+	if tbl.Entries.Len() != 1 {
+		t.Fatalf("expected one entry, got %d", tbl.Entries.Len())
+	}
+	val, ok := tbl.Entries.Get(Number{Value: math.NaN()})
+	if !ok || !Equal(val, Text{Value: "second"}) {
+		t.Fatalf("expected to find the NaN key, got %v", val)
+	}
+}
+
+func TestFunctionsHashByIdentity(t *testing.T) {
+	f := Function{Parameters: orderedmap.NewOrderedMap[TableKey, Value]()}
+	g := Function{Parameters: orderedmap.NewOrderedMap[TableKey, Value]()}
+	if !Equal(f, f) || Hash(f) != Hash(f) {
+		t.Fatalf("expected a function to be equal to itself and hash the same")
+	}
+	if Equal(f, g) {
+		t.Fatalf("expected two functions to be different")
+	}
+	if Hash(f) == Hash(g) {
+		t.Fatalf("expected two functions to hash differently")
+	}
+	tbl := table(f, Number{Value: 1}, g, Number{Value: 2})
+	if val, ok := tbl.Entries.Get(g); !ok || !Equal(val, Number{Value: 2}) {
+		t.Fatalf("expected to find a function key, got %v", val)
+	}
+}
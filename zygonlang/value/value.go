@@ -3,16 +3,26 @@ package value
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"thechosenzendro/zygonlang/zygonlang/ast"
+	"thechosenzendro/zygonlang/zygonlang/persistent"
 	"thechosenzendro/zygonlang/zygonlang/types"
 
 	"github.com/elliotchance/orderedmap/v2"
 )
 
+// Value is a value of the language. Values that are equal have the same hash.
 type Value interface {
 	Type() string
 	Inspect() string
+	// Equal tells whether the value is the same as other. Numbers, booleans and text are
+	// the same when their contents are, tables when they have the same entries in any order,
+	// and functions only to themselves.
+	Equal(other Value) bool
+	Hash() uint64
 }
 
 type Number struct {
@@ -22,12 +32,38 @@ type Number struct {
 func (n Number) Type() string    { return types.NUMBER }
 func (n Number) Inspect() string { return strconv.FormatFloat(n.Value, 'f', -1, 64) }
 
+// Equal tells whether other is the same number. Every NaN is the same value, so a NaN
+// can be found again as the key of a table.
+func (n Number) Equal(other Value) bool {
+	o, ok := other.(Number)
+	return ok && (n.Value == o.Value || math.IsNaN(n.Value) && math.IsNaN(o.Value))
+}
+
+// Hash adds zero to the number first, which turns -0 into 0, which is equal to it.
+// Every NaN hashes the same, like they are equal.
+func (n Number) Hash() uint64 {
+	if math.IsNaN(n.Value) {
+		return hashBits(kindNumber, math.Float64bits(math.NaN()))
+	}
+	return hashBits(kindNumber, math.Float64bits(n.Value+0))
+}
+
 type Boolean struct {
 	Value bool
 }
 
 func (b Boolean) Type() string    { return types.BOOL }
 func (b Boolean) Inspect() string { return fmt.Sprintf("%t", b.Value) }
+func (b Boolean) Equal(other Value) bool {
+	o, ok := other.(Boolean)
+	return ok && b.Value == o.Value
+}
+func (b Boolean) Hash() uint64 {
+	if b.Value {
+		return hashBits(kindBoolean, 1)
+	}
+	return hashBits(kindBoolean, 0)
+}
 
 type Text struct {
 	Value string
@@ -35,19 +71,118 @@ type Text struct {
 
 func (t Text) Type() string    { return types.TEXT }
 func (t Text) Inspect() string { return t.Value }
+func (t Text) Equal(other Value) bool {
+	o, ok := other.(Text)
+	return ok && t.Value == o.Value
+}
+func (t Text) Hash() uint64 { return hashText(kindText, t.Value) }
 
 type Function struct {
 	Parameters *orderedmap.OrderedMap[TableKey, Value]
 	Body       ast.Block
 	Rest       *ast.RestOperator
 	Env        *Environment
+	// Locals is the number of slots in the scope of a call
+	Locals int
 }
 
 func (f Function) Type() string    { return types.FUNCTION }
 func (f Function) Inspect() string { return "Function Declaration" }
 
+// Equal tells whether other is the same function. Each time a function declaration
+// is run it makes a function with parameters of its own, which tell it apart.
+func (f Function) Equal(other Value) bool {
+	o, ok := other.(Function)
+	return ok && f.Parameters == o.Parameters
+}
+func (f Function) Hash() uint64 { return FunctionHash(f.Parameters) }
+
+// Table is a table value. Its entries are never changed, changing a table makes a new one
+// that shares the entries it did not change with the old one.
 type Table struct {
-	Entries *orderedmap.OrderedMap[Value, Value]
+	Entries *persistent.OrderedMap[Value, Value]
+}
+
+var emptyEntries = persistent.NewOrderedMap[Value, Value](Hash, Equal)
+
+// NewTable returns a table without entries.
+func NewTable() Table {
+	return Table{Entries: emptyEntries}
+}
+
+// Set returns t with the entry at key set to val.
+func (t Table) Set(key Value, val Value) Table {
+	return Table{Entries: t.Entries.Set(key, val)}
+}
+
+// Positional returns the number of entries of t without a name.
+func (t Table) Positional() int {
+	has := func(i int) bool {
+		_, ok := t.Entries.Get(Number{Value: float64(i)})
+		return ok
+	}
+	// the entries without a name are numbered from 0 without gaps, so the first
+	// missing number is found below a bound past it
+	bound := 1
+	for has(bound - 1) {
+		bound *= 2
+	}
+	return sort.Search(bound, func(i int) bool { return !has(i) })
+}
+
+// SpreadInto returns into with the entries of t added to it, and the last number of an entry
+// without a name in it, which was index before. The entries of t without a name are numbered
+// after the ones of into, its other entries keep their keys.
+func (t Table) SpreadInto(into Table, index int) (Table, int) {
+	positional := t.Positional()
+	if into.Entries.Len() == 0 {
+		// t is shared instead of copied when nothing comes before it
+		return t, positional - 1
+	}
+	for _, key := range t.Entries.Keys() {
+		val, _ := t.Entries.Get(key)
+		if i, ok := positionOf(key, positional); ok {
+			key = Number{Value: float64(i + index + 1)}
+		}
+		into = into.Set(key, val)
+	}
+	return into, index + positional
+}
+
+// Rest returns the entries of t that a table pattern did not match, for the rest of the pattern.
+// The pattern matched the entries at keys and its first positional entries without a name,
+// the entries without a name after them are numbered again from 0.
+func (t Table) Rest(keys []Value, positional int) Table {
+	rest := t
+	if positional > 0 {
+		count := t.Positional()
+		rest = NewTable()
+		for _, key := range t.Entries.Keys() {
+			val, _ := t.Entries.Get(key)
+			if i, ok := positionOf(key, count); ok {
+				if i < positional {
+					continue
+				}
+				key = Number{Value: float64(i - positional)}
+			}
+			rest = rest.Set(key, val)
+		}
+	}
+	// without entries to number again the rest shares its entries with t
+	for _, key := range keys {
+		rest.Entries = rest.Entries.Delete(key)
+	}
+	return rest
+}
+
+// positionOf returns the position of the entry at key among the positional entries
+// of a table, which are numbered from 0.
+func positionOf(key Value, positional int) (int, bool) {
+	n, ok := key.(Number)
+	if !ok || n.Value < 0 || n.Value >= float64(positional) || n.Value != math.Trunc(n.Value) {
+		return 0, false
+	}
+	return int(n.Value), true
 }
 
 var tableIndentLevel int
@@ -72,6 +207,9 @@ func (t Table) Inspect() string {
 		switch key.(type) {
 		case Text:
 			k = "\"" + key.Inspect() + "\""
+		case Table:
+			// the entry goes on after the closing brace of a table key
+			k = strings.TrimSuffix(key.Inspect(), "\n")
 		default:
 			k = key.Inspect()
 		}
@@ -90,12 +228,43 @@ func (t Table) Inspect() string {
 	return out.String()
 }
 
+// Equal tells whether other is a table with the same entries, whatever order they are in.
+func (t Table) Equal(other Value) bool {
+	o, ok := other.(Table)
+	if !ok || t.Entries.Len() != o.Entries.Len() {
+		return false
+	}
+	for _, key := range t.Entries.Keys() {
+		val, _ := t.Entries.Get(key)
+		otherVal, ok := o.Entries.Get(key)
+		if !ok || !Equal(val, otherVal) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash adds up the hashes of the entries, so the order they are in does not change it.
+func (t Table) Hash() uint64 {
+	h := hashBits(kindTable, uint64(t.Entries.Len()))
+	for _, key := range t.Entries.Keys() {
+		val, _ := t.Entries.Get(key)
+		h += hashBits(kindEntry, Hash(key)*fnvPrime^Hash(val))
+	}
+	return h
+}
+
 type TableKey struct {
 	Value string
 }
 
 func (tk TableKey) Type() string    { return types.TABLE_KEY }
 func (tk TableKey) Inspect() string { return tk.Value }
+func (tk TableKey) Equal(other Value) bool {
+	o, ok := other.(TableKey)
+	return ok && tk.Value == o.Value
+}
+func (tk TableKey) Hash() uint64 { return hashText(kindTableKey, tk.Value) }
 
 type BuiltinFunctionContract struct {
 	Parameters *orderedmap.OrderedMap[TableKey, Value]
@@ -104,18 +273,30 @@ type BuiltinFunctionContract struct {
 
 type BuiltinFunction struct {
 	Contract BuiltinFunctionContract
-	Fn       func(args map[string]Value) Value
+	Fn       func(args map[string]Value) (Value, error)
 }
 
 func (b BuiltinFunction) Type() string    { return types.BUILTIN }
 func (b BuiltinFunction) Inspect() string { return "Builtin" }
 
+// Equal tells whether other is the same builtin function, each of which has parameters of its own.
+func (b BuiltinFunction) Equal(other Value) bool {
+	o, ok := other.(BuiltinFunction)
+	return ok && b.Contract.Parameters == o.Contract.Parameters
+}
+func (b BuiltinFunction) Hash() uint64 { return FunctionHash(b.Contract.Parameters) }
+
 type Error struct {
 	Value string
 }
 
 func (e Error) Type() string    { return types.ERROR }
 func (e Error) Inspect() string { return fmt.Sprintf("Error(%s)", e.Value) }
+func (e Error) Equal(other Value) bool {
+	o, ok := other.(Error)
+	return ok && e.Value == o.Value
+}
+func (e Error) Hash() uint64 { return hashText(kindError, e.Value) }
 
 type Type struct {
 	Value string
@@ -123,12 +304,78 @@ type Type struct {
 
 func (t Type) Type() string    { return types.TYPE }
 func (t Type) Inspect() string { return fmt.Sprintf("Type(%s)", t.Value) }
+func (t Type) Equal(other Value) bool {
+	o, ok := other.(Type)
+	return ok && t.Value == o.Value
+}
+func (t Type) Hash() uint64 { return hashText(kindType, t.Value) }
 
+// TypeName returns the name of the type of a value for messages, "nothing" for nil.
+func TypeName(val Value) string {
+	if val == nil {
+		return "nothing"
+	}
+	return val.Type()
+}
+
+// TypeOf returns the type of a value, as Type.type gives it. Builtin functions are functions
+// like any other. It returns false for values without a type, like nil.
+func TypeOf(val Value) (Type, bool) {
+	if val == nil {
+		return Type{}, false
+	}
+	switch val.Type() {
+	case types.NUMBER, types.BOOL, types.TEXT, types.FUNCTION, types.TABLE, types.ERROR, types.TYPE:
+		return Type{Value: val.Type()}, true
+	case types.BUILTIN:
+		return Type{Value: types.FUNCTION}, true
+	}
+	return Type{}, false
+}
+
+// Environment holds the names of a scope. The global scope keeps them in Store by name,
+// a local scope keeps them in Slots, by the slots the resolver gave them.
 type Environment struct {
 	Store map[string]Value
+	Slots []Value
 	Outer *Environment
 }
 
+// nilSlot is kept in a slot whose name was set to nil, to tell it apart from a slot
+// that was never set.
+type nilSlot struct{}
+
+func (n nilSlot) Type() string    { return "" }
+func (n nilSlot) Inspect() string { return "" }
+func (n nilSlot) Equal(other Value) bool {
+	_, ok := other.(nilSlot)
+	return ok
+}
+func (n nilSlot) Hash() uint64 { return 0 }
+
+// GetAt returns the value in slot of the scope depth scopes out from e.
+func (e *Environment) GetAt(depth int, slot int) (Value, bool) {
+	for range depth {
+		e = e.Outer
+	}
+	val := e.Slots[slot]
+	if val == nil {
+		return nil, false
+	}
+	if _, ok := val.(nilSlot); ok {
+		return nil, true
+	}
+	return val, true
+}
+
+// SetAt sets slot of e.
+func (e *Environment) SetAt(slot int, val Value) {
+	if val == nil {
+		val = nilSlot{}
+	}
+	e.Slots[slot] = val
+}
+
 func (e *Environment) Get(name string) (Value, bool) {
 	val, ok := e.Store[name]
 	if !ok && e.Outer != nil {